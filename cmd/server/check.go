@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/cluster"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/config"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+)
+
+// seedDialTimeout bounds the reachability check of a single seed
+const seedDialTimeout = 3 * time.Second
+
+// checkResult is the outcome of a single preflight check
+type checkResult struct {
+	name string
+	err  error
+	note string // shown instead of "ok" for passed checks
+}
+
+// runCheck validates a configuration without starting the server and
+// exits non-zero if any check fails
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to configuration file (YAML)")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: check --config <path>")
+		os.Exit(2)
+	}
+
+	results := preflight(*configPath)
+
+	failed := 0
+	fmt.Println("==============================================")
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Printf("❌ %-12s %v\n", r.name, r.err)
+		case r.note != "":
+			fmt.Printf("✅ %-12s %s\n", r.name, r.note)
+		default:
+			fmt.Printf("✅ %-12s ok\n", r.name)
+		}
+	}
+	fmt.Println("==============================================")
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(results))
+		os.Exit(1)
+	}
+	fmt.Printf("All %d checks passed\n", len(results))
+}
+
+// preflight runs all checks for the config file. Later checks are skipped
+// if the config cannot be loaded.
+func preflight(configPath string) []checkResult {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return []checkResult{{name: "config", err: err}}
+	}
+	cfg.ExpandNodeName()
+	results := []checkResult{{name: "config", note: configPath}}
+
+	results = append(results, checkResult{name: "database", err: checkDatabase(cfg.Node.Database), note: cfg.Node.Database.Path})
+	results = append(results, checkResult{name: "serf bind", err: cluster.CheckBindAddr(cfg.Node.Serf.BindAddr), note: cfg.Node.Serf.BindAddr})
+	results = append(results, checkResult{name: "http bind", err: checkListen(cfg.Node.HTTP.Addr()), note: cfg.Node.HTTP.Addr()})
+
+	for _, seed := range cfg.Cluster.Seeds {
+		name := "seed " + seed
+		if seed == cfg.Node.Serf.BindAddr {
+			results = append(results, checkResult{name: name, note: "skipped (own address)"})
+			continue
+		}
+		results = append(results, checkResult{name: name, err: checkDial(seed)})
+	}
+
+	return results
+}
+
+// checkDatabase opens the database, which also checks its integrity and
+// schema, and closes it again
+func checkDatabase(cfg config.DBConfig) error {
+	// Modes are validated when loading the config
+	dirMode, _ := config.ParseFileMode(cfg.DirMode)
+	fileMode, _ := config.ParseFileMode(cfg.FileMode)
+
+	db, err := database.New(cfg.Path, database.Options{
+		DirMode:     dirMode,
+		FileMode:    fileMode,
+		ReplicaPath: cfg.ReplicaPath,
+
+		AllowNewerSchema: cfg.AllowNewerSchema(),
+	})
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// checkListen verifies that a TCP listener can be opened on addr
+func checkListen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// checkDial verifies that a seed accepts TCP connections, which Serf uses
+// to join
+func checkDial(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, seedDialTimeout)
+	if err != nil {
+		return fmt.Errorf("unreachable: %w", err)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// loadgenStats collects the results of a load generator run
+type loadgenStats struct {
+	sent      atomic.Int64
+	succeeded atomic.Int64
+	failed    atomic.Int64
+}
+
+// runLoadgen creates todos at a fixed rate against a target node and
+// reports throughput and error rate
+func runLoadgen(args []string) {
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "Base URL of the target node")
+	rate := fs.Int("rate", 10, "Todos to create per second")
+	duration := fs.Duration("duration", 10*time.Second, "How long to generate load")
+	fs.Parse(args)
+
+	if *rate <= 0 {
+		log.Fatalf("Invalid rate: %d (must be positive)", *rate)
+	}
+
+	stats := loadgen(strings.TrimRight(*target, "/"), *rate, *duration)
+
+	sent := stats.sent.Load()
+	succeeded := stats.succeeded.Load()
+	failed := stats.failed.Load()
+
+	errorRate := 0.0
+	if sent > 0 {
+		errorRate = float64(failed) / float64(sent) * 100
+	}
+
+	fmt.Println("==============================================")
+	fmt.Printf("Target:      %s\n", *target)
+	fmt.Printf("Duration:    %v\n", *duration)
+	fmt.Printf("Sent:        %d\n", sent)
+	fmt.Printf("Succeeded:   %d\n", succeeded)
+	fmt.Printf("Failed:      %d\n", failed)
+	fmt.Printf("Throughput:  %.1f todos/s\n", float64(succeeded)/duration.Seconds())
+	fmt.Printf("Error rate:  %.1f%%\n", errorRate)
+	fmt.Println("==============================================")
+}
+
+// loadgen sends create requests to target at the given rate for the given
+// duration and waits for all in-flight requests to finish
+func loadgen(target string, rate int, duration time.Duration) *loadgenStats {
+	stats := &loadgenStats{}
+	client := &http.Client{Timeout: 10 * time.Second}
+	runID := time.Now().UnixNano()
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	var wg sync.WaitGroup
+	for n := 0; ; n++ {
+		select {
+		case <-deadline:
+			wg.Wait()
+			return stats
+		case <-ticker.C:
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				stats.sent.Add(1)
+
+				input := models.CreateTodoInput{
+					ExternID: fmt.Sprintf("loadgen-%d-%d", runID, n),
+					Todo:     fmt.Sprintf("Load test todo %d", n),
+				}
+				if err := createTodo(client, target, input); err != nil {
+					stats.failed.Add(1)
+					return
+				}
+				stats.succeeded.Add(1)
+			}(n)
+		}
+	}
+}
+
+// createTodo posts a single todo to the target node
+func createTodo(client *http.Client, target string, input models.CreateTodoInput) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(target+"/todos", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -8,10 +8,13 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -19,9 +22,12 @@ import (
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/cluster"
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/config"
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/metrics"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/version"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // slogWriter adapts slog to io.Writer interface for standard log package
@@ -44,6 +50,20 @@ func generateEncryptionKey() (string, error) {
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		runLoadgen(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		runCheck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatus(os.Args[2:])
+		return
+	}
+
 	// Command line flags
 	configFlag := flag.String("config", "", "Path to configuration file (YAML)")
 	portFlag := flag.String("port", "", "HTTP server port (overrides config)")
@@ -99,17 +119,31 @@ func main() {
 					BindAddr: "0.0.0.0:7946",
 				},
 				HTTP: config.HTTPConfig{
-					Port: 8080,
+					Port:            8080,
+					CompressMinSize: 1024,
 				},
 				Database: config.DBConfig{
 					Path: "./todos.db",
 				},
 			},
 			Cluster: config.ClusterConfig{
-				Seeds:       []string{},
-				JoinTimeout: 10,
+				Seeds:        []string{},
+				JoinTimeout:  30,
+				LeaveTimeout: 5,
+				Repair: config.RepairConfig{
+					SampleSize: 10,
+				},
+			},
+			ShutdownTimeout: 10,
+			TTL: config.TTLConfig{
+				SweepInterval: 60,
 			},
 		}
+
+		// The encryption key can still come from the environment
+		if err := cfg.Cluster.LoadEncryptKey(); err != nil {
+			log.Fatalf("Failed to load encryption key: %v", err)
+		}
 	}
 
 	// Override with command line flags
@@ -129,6 +163,7 @@ func main() {
 	if *serfAddrFlag != "" {
 		cfg.Node.Serf.BindAddr = *serfAddrFlag
 	}
+	cfg.ExpandNodeName()
 
 	// Setup logger with configured level
 	logLevel := config.ParseLogLevel(cfg.LogLevel)
@@ -139,23 +174,88 @@ func main() {
 	log.SetFlags(0)
 	log.SetOutput(&slogWriter{logger: logger})
 
-	slog.Info("Starting auto-cluster-sync", "log_level", cfg.LogLevel, "node", cfg.Node.Name)
+	slog.Info("Starting auto-cluster-sync",
+		"version", version.Version,
+		"commit", version.Commit,
+		"build_date", version.BuildDate,
+		"log_level", cfg.LogLevel,
+		"node", cfg.Node.Name,
+	)
 
 	// Initialize database
 	log.Printf("Initializing database at %s", cfg.Node.Database.Path)
-	db, err := database.New(cfg.Node.Database.Path)
+	if cfg.Node.Database.ReplicaPath != "" {
+		log.Printf("Serving reads from replica at %s", cfg.Node.Database.ReplicaPath)
+	}
+	// Modes are validated when loading the config
+	dirMode, _ := config.ParseFileMode(cfg.Node.Database.DirMode)
+	fileMode, _ := config.ParseFileMode(cfg.Node.Database.FileMode)
+	db, err := database.New(cfg.Node.Database.Path, database.Options{
+		CacheSize:   cfg.Node.Database.CacheSize,
+		CacheTTL:    time.Duration(cfg.Node.Database.CacheTTL) * time.Second,
+		DirMode:     dirMode,
+		FileMode:    fileMode,
+		ReplicaPath: cfg.Node.Database.ReplicaPath,
+
+		MemoryBudgetMB:    cfg.Node.Database.MemoryBudgetMB,
+		IncrementalVacuum: cfg.Node.Database.IncrementalVacuum,
+		AllowNewerSchema:  cfg.Node.Database.AllowNewerSchema(),
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
+	if version, err := db.SchemaVersion(); err == nil && version > database.SchemaVersion {
+		log.Printf("⚠️  Database schema version %d is newer than this binary's %d, continuing because on_newer_schema is warn", version, database.SchemaVersion)
+	}
+	if cfg.Node.Database.VacuumInterval > 0 {
+		go vacuumPeriodically(db, time.Duration(cfg.Node.Database.VacuumInterval)*time.Second)
+	}
 
 	// Initialize cluster
 	log.Printf("Initializing cluster (node: %s, serf: %s)", cfg.Node.Name, cfg.Node.Serf.BindAddr)
-	clusterInstance, err := cluster.New(cfg.Node.Name, cfg.Node.Serf.BindAddr, db)
+	// The key is validated when loading the config
+	encryptKey, _ := config.ParseEncryptKey(cfg.Cluster.EncryptKey)
+	if encryptKey != nil {
+		log.Println("🔒 Serf gossip encryption enabled")
+	}
+	keyringFile := cfg.Cluster.KeyringFile
+	if keyringFile == "" {
+		keyringFile = strings.TrimSuffix(cfg.Node.Database.Path, filepath.Ext(cfg.Node.Database.Path)) + ".keyring"
+	}
+	clusterInstance, err := cluster.New(cfg.Node.Name, cfg.Node.Serf.BindAddr, db, cluster.Options{
+		LeaveTimeout:    time.Duration(cfg.Cluster.LeaveTimeout) * time.Second,
+		JoinGracePeriod: time.Duration(cfg.Cluster.JoinGracePeriod) * time.Second,
+		TotalShards:     cfg.Cluster.Sharding.TotalShards,
+		OwnedShards:     cfg.Cluster.Sharding.OwnedShards,
+		EncryptKey:      encryptKey,
+		KeyringFile:     keyringFile,
+		FetchOnMiss:     cfg.Cluster.Sharding.FetchOnMiss,
+
+		ImmutableAfterCompletion: cfg.ImmutableAfterCompletion,
+
+		QuerySizeLimit:         cfg.Cluster.PayloadLimits.Query,
+		QueryResponseSizeLimit: cfg.Cluster.PayloadLimits.QueryResponse,
+		UserEventSizeLimit:     cfg.Cluster.PayloadLimits.UserEvent,
+		ProtocolVersion:        cfg.Cluster.ProtocolVersion,
+		EventEncoding:          cfg.Cluster.EventEncoding,
+
+		MaxSyncSilence:       time.Duration(cfg.Cluster.MaxSyncSilence) * time.Second,
+		MaxConcurrentSyncs:   cfg.Cluster.MaxConcurrentSyncs,
+		BroadcastRetryMaxAge: time.Duration(cfg.Cluster.BroadcastRetryMaxAge) * time.Second,
+		UpdateCoalescePeriod: time.Duration(cfg.Cluster.UpdateCoalescePeriod) * time.Second,
+
+		TTLAfterCreation:   time.Duration(cfg.TTL.AfterCreation) * time.Second,
+		TTLAfterCompletion: time.Duration(cfg.TTL.AfterCompletion) * time.Second,
+		SweepInterval:      time.Duration(cfg.TTL.SweepInterval) * time.Second,
+
+		RepairInterval:   time.Duration(cfg.Cluster.Repair.Interval) * time.Second,
+		RepairSampleSize: cfg.Cluster.Repair.SampleSize,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize cluster: %v", err)
 	}
-	defer clusterInstance.Stop()
+	defer clusterInstance.Stop(context.Background())
 
 	// Start cluster
 	joinTimeout := time.Duration(cfg.Cluster.JoinTimeout) * time.Second
@@ -163,30 +263,80 @@ func main() {
 		log.Fatalf("Failed to start cluster: %v", err)
 	}
 
-	// Create Chi router
+	// Create API server with cluster support
+	backupDir := cfg.Node.Database.BackupDir
+	if backupDir == "" {
+		backupDir = filepath.Dir(cfg.Node.Database.Path)
+	}
+	apiServer := api.NewServer(db, clusterInstance, api.Options{
+		AdminToken: cfg.Node.HTTP.AdminToken,
+		BackupDir:  backupDir,
+		NodeName:   cfg.Node.Name,
+
+		ImmutableAfterCompletion: cfg.ImmutableAfterCompletion,
+		TTLAfterCreation:         time.Duration(cfg.TTL.AfterCreation) * time.Second,
+
+		MinMembers:      cfg.Readiness.MinMembers,
+		CheckDBWritable: cfg.Readiness.CheckDBWritable,
+	})
+
+	// Create Chi router (middlewares must be added before any routes)
 	router := chi.NewMux()
+	router.Use(metrics.HTTPMiddleware)
+	if cfg.Node.HTTP.RequestTimeout > 0 {
+		router.Use(api.TimeoutMiddleware(time.Duration(cfg.Node.HTTP.RequestTimeout) * time.Second))
+	}
+	if cfg.Node.HTTP.Compress {
+		router.Use(api.CompressMiddleware(cfg.Node.HTTP.CompressMinSize))
+	}
+	router.Use(apiServer.ReadinessMiddleware)
+	router.Use(apiServer.JSONPatchMiddleware)
 
-	// Create Huma API
-	humaAPI := humachi.New(router, huma.DefaultConfig("Todo API", "1.0.0"))
+	// Create Huma API with CSV export support
+	humaConfig := huma.DefaultConfig("Todo API", "1.0.0")
+	humaConfig.Formats = maps.Clone(humaConfig.Formats)
+	humaConfig.Formats["text/csv"] = api.CSVFormat
+	humaConfig.Formats["csv"] = api.CSVFormat
+	humaAPI := humachi.New(router, humaConfig)
 
-	// Register routes with cluster support
-	apiServer := api.NewServer(db, clusterInstance)
+	// Register routes
 	apiServer.RegisterRoutes(humaAPI)
 
+	// Expose Prometheus metrics
+	router.Handle("/metrics", promhttp.Handler())
+
 	// Create HTTP server
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Node.HTTP.Port),
+		Addr:         cfg.Node.HTTP.Addr(),
 		Handler:      router,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve HTTP/1.1 and HTTP/2. HTTP/2 is negotiated over TLS, and
+	// accepted as cleartext h2c on plain HTTP if enabled.
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(cfg.Node.HTTP.H2C)
+
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting HTTP server on port %d", cfg.Node.HTTP.Port)
-		log.Printf("API documentation available at http://localhost:%d/docs", cfg.Node.HTTP.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		scheme := "http"
+		if cfg.Node.HTTP.TLSEnabled() {
+			scheme = "https"
+		}
+		log.Printf("Starting HTTP server on %s (%s)", srv.Addr, scheme)
+		log.Printf("API documentation available at %s://localhost:%d/docs", scheme, cfg.Node.HTTP.Port)
+
+		var err error
+		if cfg.Node.HTTP.TLSEnabled() {
+			err = srv.ListenAndServeTLS(cfg.Node.HTTP.TLSCertFile, cfg.Node.HTTP.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
@@ -197,19 +347,54 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	apiServer.BeginShutdown()
 
-	// Gracefully shutdown cluster first
-	if err := clusterInstance.Stop(); err != nil {
-		log.Printf("Error stopping cluster: %v", err)
-	}
-
-	// Then shutdown HTTP server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Bound the whole shutdown so a blocked cluster leave can't hang the process
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// Stop accepting new connections and drain in-flight requests first,
+		// so requests that are still broadcasting find the cluster running
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Server forced to shutdown: %v", err)
+		}
+
+		// Then shutdown the cluster
+		if err := clusterInstance.Stop(ctx); err != nil {
+			log.Printf("Error stopping cluster: %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Shutdown deadline of %v exceeded, forcing exit", shutdownTimeout)
+		db.Close()
+		os.Exit(1)
 	}
 
 	log.Println("Server exited")
 }
+
+// vacuumPeriodically reclaims space freed by deleted todos every interval
+// for the lifetime of the process
+func vacuumPeriodically(db *database.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reclaimed, err := db.IncrementalVacuum()
+		if err != nil {
+			log.Printf("⚠️  Incremental vacuum failed: %v", err)
+			continue
+		}
+		if reclaimed > 0 {
+			log.Printf("🧹 Incremental vacuum reclaimed %d bytes", reclaimed)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/api"
+)
+
+// runStatus prints the cluster status as seen by a node
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "Base URL of the node to ask")
+	fs.Parse(args)
+
+	info, err := fetchHealthInfo(strings.TrimRight(*target, "/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	printStatus(os.Stdout, info)
+}
+
+// fetchHealthInfo gets /health/info from a node
+func fetchHealthInfo(target string) (*api.HealthInfoResponse, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(target + "/health/info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s/health/info returned %s", target, resp.Status)
+	}
+
+	info := &api.HealthInfoResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&info.Body); err != nil {
+		return nil, fmt.Errorf("failed to decode health info: %w", err)
+	}
+	return info, nil
+}
+
+// printStatus writes the node summary and a table of the members
+func printStatus(w io.Writer, info *api.HealthInfoResponse) {
+	body := info.Body
+
+	lastSync := "never"
+	if body.SecondsSinceLastSync != nil {
+		lastSync = (time.Duration(*body.SecondsSinceLastSync) * time.Second).String() + " ago"
+	}
+
+	fmt.Fprintln(w, "==============================================")
+	fmt.Fprintf(w, "Node:        %s\n", body.NodeName)
+	fmt.Fprintf(w, "Ready:       %t\n", body.Ready)
+	fmt.Fprintf(w, "Todos:       %d\n", body.TodoCount)
+	if !body.ClusterMode {
+		fmt.Fprintln(w, "Cluster:     standalone")
+		fmt.Fprintln(w, "==============================================")
+		return
+	}
+	fmt.Fprintf(w, "Members:     %d\n", body.MemberCount)
+	fmt.Fprintf(w, "Last sync:   %s\n", lastSync)
+	fmt.Fprintln(w, "==============================================")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tSTATUS\tRTT")
+	for _, member := range body.Members {
+		rtt := "-"
+		if member.RTTMs != nil {
+			rtt = fmt.Sprintf("%.1fms", *member.RTTMs)
+		}
+		if member.Name == body.NodeName {
+			rtt = "(self)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", member.Name, member.Addr, member.Status, rtt)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	// activityKeepAlive is how often a comment is sent on an idle activity
+	// stream, so proxies don't close it
+	activityKeepAlive = 15 * time.Second
+
+	// activityWriteTimeout bounds a single write to an activity stream. It
+	// replaces the server's write timeout, which would end the stream.
+	activityWriteTimeout = 10 * time.Second
+)
+
+type ClusterActivityRequest struct {
+	Category []string `query:"category" enum:"member,sync" doc:"Only stream events of these categories (default: all)"`
+}
+
+func (s *Server) clusterActivity(ctx context.Context, input *ClusterActivityRequest) (*huma.StreamResponse, error) {
+	if s.cluster == nil {
+		return nil, newError(http.StatusBadRequest, CodeStandaloneMode, "Running in standalone mode, no cluster activity to stream")
+	}
+
+	categories := make(map[string]bool, len(input.Category))
+	for _, category := range input.Category {
+		categories[category] = true
+	}
+
+	return &huma.StreamResponse{
+		Body: func(hctx huma.Context) {
+			events, cancel := s.cluster.Subscribe()
+			defer cancel()
+
+			hctx.SetHeader("Content-Type", "text/event-stream")
+			hctx.SetHeader("Cache-Control", "no-cache")
+			hctx.SetStatus(http.StatusOK)
+			w, ok := hctx.BodyWriter().(http.ResponseWriter)
+			if !ok {
+				log.Printf("❌ Cannot stream cluster activity: body writer is not an http.ResponseWriter")
+				return
+			}
+			rc := http.NewResponseController(w)
+
+			// Send the headers right away so clients see the stream is open
+			if err := writeEvent(rc, w, ": connected\n\n"); err != nil {
+				return
+			}
+
+			keepAlive := time.NewTicker(activityKeepAlive)
+			defer keepAlive.Stop()
+
+			for {
+				var err error
+				select {
+				case <-hctx.Context().Done():
+					return
+				case <-s.shutdown:
+					return
+				case <-keepAlive.C:
+					err = writeEvent(rc, w, ": keep-alive\n\n")
+				case event := <-events:
+					if len(categories) > 0 && !categories[event.Category] {
+						continue
+					}
+					data, marshalErr := json.Marshal(event)
+					if marshalErr != nil {
+						log.Printf("❌ Failed to marshal activity event: %v", marshalErr)
+						continue
+					}
+					err = writeEvent(rc, w, fmt.Sprintf("event: %s\ndata: %s\n\n", event.Category, data))
+				}
+				if err != nil {
+					return
+				}
+			}
+		},
+	}, nil
+}
+
+// writeEvent writes a Server-Sent Events message and flushes it
+func writeEvent(rc *http.ResponseController, w io.Writer, msg string) error {
+	rc.SetWriteDeadline(time.Now().Add(activityWriteTimeout))
+	if _, err := io.WriteString(w, msg); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
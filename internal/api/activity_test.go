@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/cluster"
+)
+
+func TestClusterActivityStreamsMemberJoin(t *testing.T) {
+	c := newFakeCluster()
+	api, _ := newTestAPI(t, c, Options{})
+	srv := httptest.NewServer(api.Adapter())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/cluster/activity?category=member")
+	if err != nil {
+		t.Fatalf("GET /cluster/activity: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("activity = %d (%s), want 200 text/event-stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("activity stream ended")
+			}
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the activity stream")
+		}
+		return ""
+	}
+
+	if line := next(); line != ": connected" {
+		t.Fatalf("first line = %q, want the connected comment", line)
+	}
+	if line := next(); line != "" {
+		t.Fatalf("line after the connected comment = %q, want it to end the message", line)
+	}
+
+	// Sync events are filtered out by the category
+	c.activity <- cluster.ActivityEvent{Category: cluster.ActivitySync, Type: "created", Node: "node-b", ExternID: "todo-1"}
+	c.activity <- cluster.ActivityEvent{Category: cluster.ActivityMember, Type: "join", Node: "node-b", Addr: "10.0.0.2", Time: time.Now()}
+
+	if line := next(); line != "event: member" {
+		t.Fatalf("event line = %q, want %q", line, "event: member")
+	}
+	data, ok := strings.CutPrefix(next(), "data: ")
+	if !ok {
+		t.Fatal("event without data")
+	}
+	var event cluster.ActivityEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if event.Category != cluster.ActivityMember || event.Type != "join" || event.Node != "node-b" || event.Addr != "10.0.0.2" {
+		t.Errorf("event = %+v, want node-b joining from 10.0.0.2", event)
+	}
+}
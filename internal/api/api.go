@@ -2,10 +2,23 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/cluster"
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/version"
 	"github.com/danielgtaylor/huma/v2"
 )
 
@@ -13,24 +26,79 @@ import (
 type Cluster interface {
 	BroadcastTodoCreated(todo *models.Todo) error
 	BroadcastTodoUpdated(todo *models.Todo) error
-	BroadcastTodoDeleted(externID string) error
+	BroadcastTodoDeleted(namespace, externID string) error
+	FetchTodo(namespace, externID string) (*models.Todo, error)
+	CheckTodoSize(todo *models.Todo) error
 	IsReady() bool
 	LocalNode() string
 	MemberCount() int
+	AliveMemberCount() int
 	GetMemberInfo() []models.ClusterMemberInfo
+	Leave() error
+	HasLeft() bool
+	Resync() (cluster.SyncResult, error)
+	Subscribe() (<-chan cluster.ActivityEvent, func())
+	LastSyncAt() time.Time
+	SyncSilent() bool
+	Keyring() (*cluster.KeyringStatus, error)
+	RotateKey(key string) (*cluster.KeyringStatus, error)
+	Topology() cluster.Topology
 }
 
 // Server holds the API server dependencies
 type Server struct {
 	db      *database.DB
 	cluster Cluster
+	opts    Options
+
+	// Closed once shutdown begins; fails readiness and ends live streams
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// Options contains optional API server settings
+type Options struct {
+	AdminToken string // Bearer token for /admin endpoints (empty disables them)
+	BackupDir  string // Directory for database backups
+	NodeName   string // Recorded as origin_node of todos created via this server
+
+	// Reject changes to completed todos with 409
+	ImmutableAfterCompletion bool
+
+	// Todos created via this server expire this long after creation (0
+	// disables); see expiresAt
+	TTLAfterCreation time.Duration
+
+	// Additional readiness criteria for /health/ready
+	MinMembers      int  // Alive members, including this node, required to be ready (0 disables)
+	CheckDBWritable bool // Require the database to accept writes
 }
 
 // NewServer creates a new API server
-func NewServer(db *database.DB, cluster Cluster) *Server {
+func NewServer(db *database.DB, cluster Cluster, opts Options) *Server {
 	return &Server{
 		db:      db,
 		cluster: cluster,
+		opts:    opts,
+
+		shutdown: make(chan struct{}),
+	}
+}
+
+// BeginShutdown marks the node as shutting down, so readiness fails and load
+// balancers stop routing here while the shutdown work proceeds. Live streams
+// are ended so they don't hold up draining the HTTP server.
+func (s *Server) BeginShutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// isShuttingDown returns true once BeginShutdown was called
+func (s *Server) isShuttingDown() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -56,16 +124,143 @@ func (s *Server) RegisterRoutes(api huma.API) {
 		Tags:        []string{"health"},
 	}, s.healthInfo)
 
+	// GET /version - Build information
+	huma.Register(api, huma.Operation{
+		OperationID: "version",
+		Method:      http.MethodGet,
+		Path:        "/version",
+		Summary:     "Build information",
+		Description: "Get the version, commit and build date of the running binary (\"dev\" if not set at build time)",
+		Tags:        []string{"health"},
+	}, s.version)
+
+	// POST /cluster/leave - Leave the cluster
+	huma.Register(api, huma.Operation{
+		OperationID: "cluster-leave",
+		Method:      http.MethodPost,
+		Path:        "/cluster/leave",
+		Summary:     "Leave the cluster",
+		Description: "Gracefully leave the cluster without stopping the process (for decommissioning). Requires the admin token.",
+		Tags:        []string{"cluster"},
+	}, s.clusterLeave)
+
+	// POST /cluster/resync - Manual full resync
+	huma.Register(api, huma.Operation{
+		OperationID: "cluster-resync",
+		Method:      http.MethodPost,
+		Path:        "/cluster/resync",
+		Summary:     "Resync from the cluster",
+		Description: "Run a full sync from the cluster on demand, e.g. after detecting divergence, and return once it completes. Requires the admin token.",
+		Tags:        []string{"cluster"},
+	}, s.clusterResync)
+
+	// GET /cluster/events - Cluster membership audit log
+	huma.Register(api, huma.Operation{
+		OperationID: "cluster-events",
+		Method:      http.MethodGet,
+		Path:        "/cluster/events",
+		Summary:     "Cluster membership events",
+		Description: "Get the persisted log of cluster membership changes (join, leave, failed, update, reap), newest first",
+		Tags:        []string{"cluster"},
+	}, s.clusterEvents)
+
+	// GET /cluster/topology - Members and latencies as a graph
+	huma.Register(api, huma.Operation{
+		OperationID: "cluster-topology",
+		Method:      http.MethodGet,
+		Path:        "/cluster/topology",
+		Summary:     "Cluster topology",
+		Description: "Get the cluster members as nodes and the round-trip times between them, estimated from Serf's network coordinates, as weighted edges for graphing",
+		Tags:        []string{"cluster"},
+	}, s.clusterTopology)
+
+	// GET /cluster/activity - Live cluster activity
+	huma.Register(api, huma.Operation{
+		OperationID: "cluster-activity",
+		Method:      http.MethodGet,
+		Path:        "/cluster/activity",
+		Summary:     "Stream cluster activity",
+		Description: "Stream membership changes and todo changes applied from other nodes as Server-Sent Events, optionally limited to some categories",
+		Tags:        []string{"cluster"},
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "Server-Sent Events named after the event category, with an ActivityEvent as data",
+				Content: map[string]*huma.MediaType{
+					"text/event-stream": {Schema: &huma.Schema{Type: huma.TypeString}},
+				},
+			},
+		},
+	}, s.clusterActivity)
+
+	// POST /admin/backup - Online database backup
+	huma.Register(api, huma.Operation{
+		OperationID: "admin-backup",
+		Method:      http.MethodPost,
+		Path:        "/admin/backup",
+		Summary:     "Back up the database",
+		Description: "Write a consistent snapshot of the database to a timestamped file while the node stays online. Requires the admin token as bearer token",
+		Tags:        []string{"admin"},
+	}, s.adminBackup)
+
+	// POST /admin/vacuum - Reclaim space freed by deletes
+	huma.Register(api, huma.Operation{
+		OperationID: "admin-vacuum",
+		Method:      http.MethodPost,
+		Path:        "/admin/vacuum",
+		Summary:     "Vacuum database",
+		Description: "Return pages freed by deleted todos to the OS with SQLite's incremental vacuum. Has no effect unless database.incremental_vacuum is enabled. Requires the admin token as bearer token",
+		Tags:        []string{"admin"},
+	}, s.adminVacuum)
+
+	// GET /admin/keyring - Gossip encryption keys in use
+	huma.Register(api, huma.Operation{
+		OperationID: "admin-keyring",
+		Method:      http.MethodGet,
+		Path:        "/admin/keyring",
+		Summary:     "List gossip encryption keys",
+		Description: "Report the Serf encryption keys installed on the cluster members, identified by fingerprint. Requires the admin token as bearer token",
+		Tags:        []string{"admin"},
+	}, s.adminKeyring)
+
+	// POST /admin/keyring/rotate - Replace the gossip encryption key
+	huma.Register(api, huma.Operation{
+		OperationID: "admin-keyring-rotate",
+		Method:      http.MethodPost,
+		Path:        "/admin/keyring/rotate",
+		Summary:     "Rotate the gossip encryption key",
+		Description: "Install a new Serf encryption key on all members, make it the primary key and remove all other keys, without downtime. Requires the admin token as bearer token",
+		Tags:        []string{"admin"},
+	}, s.adminKeyringRotate)
+
 	// GET /todos - List all todos
 	huma.Register(api, huma.Operation{
 		OperationID: "list-todos",
 		Method:      http.MethodGet,
 		Path:        "/todos",
 		Summary:     "List all todos",
-		Description: "Get a list of all todo items",
+		Description: "Get a list of all todo items, optionally filtered by creation time and sorted. Send Accept: text/csv or ?format=csv for a CSV export",
 		Tags:        []string{"todos"},
+		Middlewares: huma.Middlewares{csvQueryMiddleware},
 	}, s.listTodos)
 
+	// GET /todos/stream - Stream all todos
+	huma.Register(api, huma.Operation{
+		OperationID: "stream-todos",
+		Method:      http.MethodGet,
+		Path:        "/todos/stream",
+		Summary:     "Stream all todos",
+		Description: "Get the same JSON array as list-todos, written incrementally while reading from the database, so memory use stays flat for large datasets",
+		Tags:        []string{"todos"},
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "JSON array of todos",
+				Content: map[string]*huma.MediaType{
+					"application/json": {Schema: &huma.Schema{Type: huma.TypeArray, Items: &huma.Schema{Ref: "#/components/schemas/Todo"}}},
+				},
+			},
+		},
+	}, s.streamTodos)
+
 	// GET /todos/{id} - Get a specific todo
 	huma.Register(api, huma.Operation{
 		OperationID: "get-todo",
@@ -92,10 +287,30 @@ func (s *Server) RegisterRoutes(api huma.API) {
 		Method:      http.MethodPut,
 		Path:        "/todos/{id}",
 		Summary:     "Update a todo",
-		Description: "Update an existing todo item",
+		Description: "Update an existing todo item. Also accepts an RFC 6902 JSON Patch (application/json-patch+json) for the todo and completed fields",
 		Tags:        []string{"todos"},
 	}, s.updateTodo)
 
+	// GET /todos/by-extern/{extern_id} - Get a todo by extern_id
+	huma.Register(api, huma.Operation{
+		OperationID: "get-todo-by-extern-id",
+		Method:      http.MethodGet,
+		Path:        "/todos/by-extern/{extern_id}",
+		Summary:     "Get a todo by extern_id",
+		Description: "Get a specific todo item by external ID. With fetch_on_miss enabled, a todo missing on this node is fetched from another node",
+		Tags:        []string{"todos"},
+	}, s.getTodoByExternID)
+
+	// PUT /todos/by-extern/{extern_id} - Create or update a todo by extern_id
+	huma.Register(api, huma.Operation{
+		OperationID: "put-todo-by-extern-id",
+		Method:      http.MethodPut,
+		Path:        "/todos/by-extern/{extern_id}",
+		Summary:     "Create or update a todo by extern_id",
+		Description: "Create the todo if no todo with this extern_id exists (201), otherwise update it (200)",
+		Tags:        []string{"todos"},
+	}, s.putTodoByExternID)
+
 	// DELETE /todos/{id} - Delete a todo
 	huma.Register(api, huma.Operation{
 		OperationID: "delete-todo",
@@ -109,20 +324,64 @@ func (s *Server) RegisterRoutes(api huma.API) {
 
 // Request/Response types
 
+// ListFilter holds the filter and sort parameters shared by the list endpoints
+type ListFilter struct {
+	Namespace     string    `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
+	CreatedAfter  time.Time `query:"created_after" doc:"Only return todos created at or after this time (RFC 3339)"`
+	CreatedBefore time.Time `query:"created_before" doc:"Only return todos created before this time (RFC 3339)"`
+	Sort          string    `query:"sort" enum:"created_at,id,completed" default:"created_at" doc:"Field to sort by"`
+	Order         string    `query:"order" enum:"asc,desc" default:"desc" doc:"Sort direction"`
+	Label         []string  `query:"label,explode" doc:"Only return todos whose metadata has this key=value label; repeat to require several"`
+}
+
+// options converts the filter into database list options
+func (f *ListFilter) options() (database.ListOptions, error) {
+	opts := database.ListOptions{
+		Namespace: f.Namespace,
+		SortBy:    f.Sort,
+		Order:     f.Order,
+	}
+	if !f.CreatedAfter.IsZero() {
+		opts.CreatedAfter = &f.CreatedAfter
+	}
+	if !f.CreatedBefore.IsZero() {
+		opts.CreatedBefore = &f.CreatedBefore
+	}
+	if len(f.Label) > 0 {
+		opts.Labels = make(map[string]string, len(f.Label))
+		for _, label := range f.Label {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok || !models.MetadataKeyPattern.MatchString(key) {
+				return opts, huma.Error422UnprocessableEntity(fmt.Sprintf("Invalid label %q, expected key=value", label))
+			}
+			opts.Labels[key] = value
+		}
+	}
+	return opts, nil
+}
+
+type ListTodosRequest struct {
+	ListFilter
+	Format string `query:"format" enum:"json,csv" doc:"Response format (alternative to the Accept header)"`
+}
+
 type ListTodosResponse struct {
 	Body []models.Todo
 }
 
 type GetTodoRequest struct {
-	ID int `path:"id" minimum:"1" doc:"Todo ID"`
+	ID        int    `path:"id" minimum:"1" doc:"Todo ID"`
+	Namespace string `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
 }
 
 type GetTodoResponse struct {
+	ETag string `header:"ETag" doc:"Current version of the todo"`
 	Body models.Todo
 }
 
 type CreateTodoRequest struct {
-	Body models.CreateTodoInput
+	Namespace string `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
+	Body      models.CreateTodoInput
 }
 
 type CreateTodoResponse struct {
@@ -130,22 +389,49 @@ type CreateTodoResponse struct {
 }
 
 type UpdateTodoRequest struct {
-	ID   int                     `path:"id" minimum:"1" doc:"Todo ID"`
-	Body models.UpdateTodoInput
+	ID        int    `path:"id" minimum:"1" doc:"Todo ID"`
+	Namespace string `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
+	IfMatch   string `header:"If-Match" doc:"Only update if the todo still has this ETag (version)"`
+	Body      models.UpdateTodoInput
 }
 
 type UpdateTodoResponse struct {
+	ETag string `header:"ETag" doc:"New version of the todo"`
 	Body models.Todo
 }
 
+type GetTodoByExternIDRequest struct {
+	ExternID  string `path:"extern_id" minLength:"1" maxLength:"80" doc:"External ID of the todo"`
+	Namespace string `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
+}
+
+type PutTodoByExternIDRequest struct {
+	ExternID  string `path:"extern_id" minLength:"1" maxLength:"80" doc:"External ID of the todo"`
+	Namespace string `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
+	Body      models.PutTodoInput
+}
+
+type PutTodoByExternIDResponse struct {
+	Status int
+	ETag   string `header:"ETag" doc:"Current version of the todo"`
+	Body   models.Todo
+}
+
 type DeleteTodoRequest struct {
-	ID int `path:"id" minimum:"1" doc:"Todo ID"`
+	ID        int    `path:"id" minimum:"1" doc:"Todo ID"`
+	Namespace string `header:"X-Namespace" pattern:"^[A-Za-z0-9_-]+$" maxLength:"64" default:"default" doc:"Namespace of the todos"`
+	IfMatch   string `header:"If-Match" doc:"Only delete if the todo still has this ETag (version)"`
 }
 
 // Handler implementations
 
-func (s *Server) listTodos(ctx context.Context, input *struct{}) (*ListTodosResponse, error) {
-	todos, err := s.db.ListTodos()
+func (s *Server) listTodos(ctx context.Context, input *ListTodosRequest) (*ListTodosResponse, error) {
+	opts, err := input.options()
+	if err != nil {
+		return nil, err
+	}
+
+	todos, err := s.db.ListTodosWithOptions(opts)
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to list todos", err)
 	}
@@ -159,20 +445,44 @@ func (s *Server) listTodos(ctx context.Context, input *struct{}) (*ListTodosResp
 }
 
 func (s *Server) getTodo(ctx context.Context, input *GetTodoRequest) (*GetTodoResponse, error) {
-	todo, err := s.db.GetTodo(input.ID)
+	todo, err := s.getTodoInNamespace(input.ID, input.Namespace)
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to get todo", err)
-	}
-
-	if todo == nil {
-		return nil, huma.Error404NotFound("Todo not found")
+		return nil, err
 	}
 
-	return &GetTodoResponse{Body: *todo}, nil
+	return &GetTodoResponse{ETag: formatETag(todo.Version), Body: *todo}, nil
 }
 
 func (s *Server) createTodo(ctx context.Context, input *CreateTodoRequest) (*CreateTodoResponse, error) {
-	todo, err := s.db.CreateTodo(input.Body.ExternID, input.Body.Todo)
+	if err := s.checkAcceptingWrites(); err != nil {
+		return nil, err
+	}
+
+	if err := input.Body.Metadata.Validate(); err != nil {
+		return nil, huma.Error422UnprocessableEntity(err.Error())
+	}
+	expiresAt := s.expiresAt(input.Body.ExpiresAt, time.Now())
+	if err := s.checkSyncSize(&models.Todo{
+		Namespace:  input.Namespace,
+		ExternID:   input.Body.ExternID,
+		Todo:       input.Body.Todo,
+		ExpiresAt:  expiresAt,
+		OriginNode: s.opts.NodeName,
+		Metadata:   input.Body.Metadata,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Reject duplicate extern_ids with a conflict instead of a database error
+	existing, err := s.db.GetTodoByExternID(input.Namespace, input.Body.ExternID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to check extern_id", err)
+	}
+	if existing != nil {
+		return nil, newError(http.StatusConflict, CodeExternIDConflict, "A todo with this extern_id already exists")
+	}
+
+	todo, err := s.db.CreateTodo(input.Namespace, input.Body.ExternID, input.Body.Todo, s.opts.NodeName, input.Body.Metadata, expiresAt)
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to create todo", err)
 	}
@@ -180,9 +490,8 @@ func (s *Server) createTodo(ctx context.Context, input *CreateTodoRequest) (*Cre
 	// Broadcast to cluster (if cluster is enabled)
 	if s.cluster != nil {
 		if err := s.cluster.BroadcastTodoCreated(todo); err != nil {
-			// Log error but don't fail the request
-			// Todo is already created locally
-			// Cluster sync will retry later
+			// Don't fail the request, the todo is already created locally
+			log.Printf("⚠️  %v", err)
 		}
 	}
 
@@ -190,51 +499,234 @@ func (s *Server) createTodo(ctx context.Context, input *CreateTodoRequest) (*Cre
 }
 
 func (s *Server) updateTodo(ctx context.Context, input *UpdateTodoRequest) (*UpdateTodoResponse, error) {
-	todo, err := s.db.UpdateTodo(input.ID, input.Body.Todo, input.Body.Completed)
+	if err := s.checkAcceptingWrites(); err != nil {
+		return nil, err
+	}
+
+	// Todos in other namespaces are not visible to this request
+	existing, err := s.getTodoInNamespace(input.ID, input.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkMutable(existing); err != nil {
+		return nil, err
+	}
+
+	if err := input.Body.Metadata.Validate(); err != nil {
+		return nil, huma.Error422UnprocessableEntity(err.Error())
+	}
+
+	// Check the todo as it will be after the update
+	updated := *existing
+	if input.Body.Todo != nil {
+		updated.Todo = *input.Body.Todo
+	}
+	if input.Body.Completed != nil {
+		updated.Completed = *input.Body.Completed
+	}
+	if input.Body.Metadata != nil {
+		updated.Metadata = input.Body.Metadata
+	}
+	if err := s.checkSyncSize(&updated); err != nil {
+		return nil, err
+	}
+
+	var todo *models.Todo
+	if input.IfMatch != "" && input.IfMatch != "*" {
+		version, ok := parseETag(input.IfMatch)
+		if !ok {
+			return nil, newError(http.StatusPreconditionFailed, CodeInvalidPrecondition, "Invalid If-Match header")
+		}
+		todo, err = s.db.UpdateTodoIfVersion(input.ID, version, input.Body.Todo, input.Body.Completed, input.Body.Metadata, nil)
+	} else {
+		todo, err = s.db.UpdateTodo(input.ID, input.Body.Todo, input.Body.Completed, input.Body.Metadata, nil)
+	}
+	if errors.Is(err, database.ErrVersionMismatch) {
+		return nil, newError(http.StatusPreconditionFailed, CodeVersionMismatch, "Todo was modified, If-Match does not match current version")
+	}
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to update todo", err)
 	}
 
 	if todo == nil {
-		return nil, huma.Error404NotFound("Todo not found")
+		return nil, newError(http.StatusNotFound, CodeTodoNotFound, "Todo not found")
 	}
 
 	// Broadcast to cluster (if cluster is enabled)
 	if s.cluster != nil {
 		if err := s.cluster.BroadcastTodoUpdated(todo); err != nil {
-			// Log error but don't fail the request
+			// Don't fail the request, the todo is already updated locally
+			log.Printf("⚠️  %v", err)
 		}
 	}
 
-	return &UpdateTodoResponse{Body: *todo}, nil
+	return &UpdateTodoResponse{ETag: formatETag(todo.Version), Body: *todo}, nil
 }
 
-func (s *Server) deleteTodo(ctx context.Context, input *DeleteTodoRequest) (*struct{}, error) {
-	// Get todo first to get extern_id for cluster broadcast
-	todo, err := s.db.GetTodo(input.ID)
+func (s *Server) getTodoByExternID(ctx context.Context, input *GetTodoByExternIDRequest) (*GetTodoResponse, error) {
+	todo, err := s.db.GetTodoByExternID(input.Namespace, input.ExternID)
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to get todo", err)
 	}
+
+	// Nodes storing only some shards may not have the todo
+	if todo == nil && s.cluster != nil {
+		todo, err = s.cluster.FetchTodo(input.Namespace, input.ExternID)
+		if err != nil {
+			return nil, huma.Error502BadGateway("Failed to fetch todo from cluster", err)
+		}
+	}
+
 	if todo == nil {
-		return nil, huma.Error404NotFound("Todo not found")
+		return nil, newError(http.StatusNotFound, CodeTodoNotFound, "Todo not found")
+	}
+
+	return &GetTodoResponse{ETag: formatETag(todo.Version), Body: *todo}, nil
+}
+
+func (s *Server) putTodoByExternID(ctx context.Context, input *PutTodoByExternIDRequest) (*PutTodoByExternIDResponse, error) {
+	if err := s.checkAcceptingWrites(); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.db.GetTodoByExternID(input.Namespace, input.ExternID)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get todo", err)
+	}
+	if err := s.checkMutable(existing); err != nil {
+		return nil, err
+	}
+
+	// Check the todo as it will be stored; a replaced todo keeps its labels
+	// and expiry
+	expiresAt := s.expiresAt(nil, time.Now())
+	stored := models.Todo{Namespace: input.Namespace, ExternID: input.ExternID, OriginNode: s.opts.NodeName, ExpiresAt: expiresAt}
+	if existing != nil {
+		stored = *existing
+	}
+	stored.Todo = input.Body.Todo
+	if input.Body.Completed != nil {
+		stored.Completed = *input.Body.Completed
+	}
+	if err := s.checkSyncSize(&stored); err != nil {
+		return nil, err
+	}
+
+	todo, created, err := s.db.PutTodoByExternID(input.Namespace, input.ExternID, input.Body.Todo, s.opts.NodeName, input.Body.Completed, expiresAt)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to put todo", err)
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	// Broadcast to cluster (if cluster is enabled)
+	if s.cluster != nil {
+		var err error
+		if created {
+			err = s.cluster.BroadcastTodoCreated(todo)
+		} else {
+			err = s.cluster.BroadcastTodoUpdated(todo)
+		}
+		if err != nil {
+			// Don't fail the request, the todo is already stored locally
+			log.Printf("⚠️  %v", err)
+		}
+	}
+
+	return &PutTodoByExternIDResponse{Status: status, ETag: formatETag(todo.Version), Body: *todo}, nil
+}
+
+func (s *Server) deleteTodo(ctx context.Context, input *DeleteTodoRequest) (*struct{}, error) {
+	if err := s.checkAcceptingWrites(); err != nil {
+		return nil, err
+	}
+
+	// Get todo first to get extern_id for cluster broadcast
+	todo, err := s.getTodoInNamespace(input.ID, input.Namespace)
+	if err != nil {
+		return nil, err
 	}
 
 	// Delete from database
-	err = s.db.DeleteTodo(input.ID)
+	if input.IfMatch != "" && input.IfMatch != "*" {
+		version, ok := parseETag(input.IfMatch)
+		if !ok {
+			return nil, newError(http.StatusPreconditionFailed, CodeInvalidPrecondition, "Invalid If-Match header")
+		}
+		err = s.db.DeleteTodoIfVersion(input.ID, version)
+	} else {
+		err = s.db.DeleteTodo(input.ID)
+	}
+	if errors.Is(err, database.ErrVersionMismatch) {
+		return nil, newError(http.StatusPreconditionFailed, CodeVersionMismatch, "Todo was modified, If-Match does not match current version")
+	}
 	if err != nil {
 		return nil, huma.Error500InternalServerError("Failed to delete todo", err)
 	}
 
 	// Broadcast to cluster (if cluster is enabled)
 	if s.cluster != nil {
-		if err := s.cluster.BroadcastTodoDeleted(todo.ExternID); err != nil {
-			// Log error but don't fail the request
+		if err := s.cluster.BroadcastTodoDeleted(todo.Namespace, todo.ExternID); err != nil {
+			// Don't fail the request, the todo is already deleted locally
+			log.Printf("⚠️  %v", err)
 		}
 	}
 
 	return nil, nil
 }
 
+// getTodoInNamespace retrieves a todo by ID, returning 404 if it does not
+// exist or belongs to a different namespace
+func (s *Server) getTodoInNamespace(id int, namespace string) (*models.Todo, error) {
+	todo, err := s.db.GetTodo(id)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to get todo", err)
+	}
+	if todo == nil || todo.Namespace != namespace {
+		return nil, newError(http.StatusNotFound, CodeTodoNotFound, "Todo not found")
+	}
+	return todo, nil
+}
+
+// checkSyncSize rejects todos too large to be synchronized to the other
+// nodes, which would otherwise silently exist on this node only
+func (s *Server) checkSyncSize(todo *models.Todo) error {
+	if s.cluster == nil {
+		return nil
+	}
+	if err := s.cluster.CheckTodoSize(todo); err != nil {
+		return newError(http.StatusRequestEntityTooLarge, CodeTodoTooLarge, err.Error())
+	}
+	return nil
+}
+
+// expiresAt returns when a todo created at now expires: at the requested
+// time or, with a TTL after creation, once it passed, whichever is first.
+// It is computed here on the origin node, so the absolute time travels with
+// the todo and all nodes expire it together, whenever they stored it.
+func (s *Server) expiresAt(requested *time.Time, now time.Time) *time.Time {
+	if s.opts.TTLAfterCreation <= 0 {
+		return requested
+	}
+	ttl := now.Add(s.opts.TTLAfterCreation)
+	if requested != nil && requested.Before(ttl) {
+		return requested
+	}
+	return &ttl
+}
+
+// checkMutable rejects changes to a completed todo if todos are immutable
+// after completion. A nil todo is about to be created and always mutable.
+func (s *Server) checkMutable(todo *models.Todo) error {
+	if s.opts.ImmutableAfterCompletion && todo != nil && todo.Completed {
+		return newError(http.StatusConflict, CodeTodoCompleted, "Todo is completed and can no longer be changed")
+	}
+	return nil
+}
+
 type HealthReadyResponse struct {
 	Body struct {
 		Ready   bool   `json:"ready" doc:"Whether the node is ready to serve requests"`
@@ -245,6 +737,18 @@ type HealthReadyResponse struct {
 func (s *Server) healthReady(ctx context.Context, input *struct{}) (*HealthReadyResponse, error) {
 	resp := &HealthReadyResponse{}
 
+	if s.isShuttingDown() {
+		resp.Body.Message = "Node is shutting down"
+		return resp, newError(http.StatusServiceUnavailable, CodeShuttingDown, "Node is shutting down")
+	}
+
+	if s.opts.CheckDBWritable {
+		if err := s.db.CheckWritable(); err != nil {
+			resp.Body.Message = "Database is not writable"
+			return resp, newError(http.StatusServiceUnavailable, CodeDatabaseNotWritable, resp.Body.Message, err)
+		}
+	}
+
 	if s.cluster == nil {
 		// No cluster, always ready
 		resp.Body.Ready = true
@@ -252,6 +756,21 @@ func (s *Server) healthReady(ctx context.Context, input *struct{}) (*HealthReady
 		return resp, nil
 	}
 
+	if s.cluster.HasLeft() {
+		resp.Body.Message = "Node has left the cluster"
+		return resp, newError(http.StatusServiceUnavailable, CodeNodeLeftCluster, resp.Body.Message)
+	}
+
+	if alive := s.cluster.AliveMemberCount(); alive < s.opts.MinMembers {
+		resp.Body.Message = fmt.Sprintf("Only %d of the required %d members are alive", alive, s.opts.MinMembers)
+		return resp, newError(http.StatusServiceUnavailable, CodeTooFewMembers, resp.Body.Message)
+	}
+
+	if s.cluster.SyncSilent() {
+		resp.Body.Message = "No changes received from other nodes recently, node may be partitioned"
+		return resp, newError(http.StatusServiceUnavailable, CodeSyncSilent, resp.Body.Message)
+	}
+
 	if s.cluster.IsReady() {
 		resp.Body.Ready = true
 		resp.Body.Message = "Node is ready"
@@ -261,7 +780,7 @@ func (s *Server) healthReady(ctx context.Context, input *struct{}) (*HealthReady
 	// Not ready yet (still syncing)
 	resp.Body.Ready = false
 	resp.Body.Message = "Node is syncing, not ready yet"
-	return resp, huma.Error503ServiceUnavailable("Node is syncing, not ready yet")
+	return resp, newError(http.StatusServiceUnavailable, CodeClusterNotReady, "Node is syncing, not ready yet")
 }
 
 type HealthInfoResponse struct {
@@ -272,6 +791,8 @@ type HealthInfoResponse struct {
 		MemberCount  int                         `json:"member_count" doc:"Number of cluster members"`
 		Members      []models.ClusterMemberInfo  `json:"members,omitempty" doc:"List of cluster members"`
 		TodoCount    int                         `json:"todo_count" doc:"Number of todos in local database"`
+
+		SecondsSinceLastSync *int64 `json:"seconds_since_last_sync,omitempty" doc:"Seconds since a change from another node was applied or a full sync completed, omitted if neither happened yet"`
 	}
 }
 
@@ -301,6 +822,354 @@ func (s *Server) healthInfo(ctx context.Context, input *struct{}) (*HealthInfoRe
 	resp.Body.ClusterMode = true
 	resp.Body.MemberCount = s.cluster.MemberCount()
 	resp.Body.Members = s.cluster.GetMemberInfo()
+	if lastSync := s.cluster.LastSyncAt(); !lastSync.IsZero() {
+		seconds := int64(time.Since(lastSync).Seconds())
+		resp.Body.SecondsSinceLastSync = &seconds
+	}
+
+	return resp, nil
+}
+
+type VersionResponse struct {
+	Body struct {
+		Version   string `json:"version" doc:"Release version"`
+		Commit    string `json:"commit" doc:"Source commit"`
+		BuildDate string `json:"build_date" doc:"Build date"`
+	}
+}
+
+func (s *Server) version(ctx context.Context, input *struct{}) (*VersionResponse, error) {
+	resp := &VersionResponse{}
+	resp.Body.Version = version.Version
+	resp.Body.Commit = version.Commit
+	resp.Body.BuildDate = version.BuildDate
+	return resp, nil
+}
+
+// formatETag formats a todo version as a strong ETag
+func formatETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag parses a todo version from an ETag, accepting quoted,
+// unquoted and weak (W/) forms
+func parseETag(etag string) (int, bool) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	version, err := strconv.Atoi(strings.Trim(etag, `"`))
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// ReadinessMiddleware rejects requests to the /todos routes with 503 until
+// the node is ready, so clients never read partially synced data
+func (s *Server) ReadinessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isTodoRoute := r.URL.Path == "/todos" || strings.HasPrefix(r.URL.Path, "/todos/")
+		if !isTodoRoute || s.cluster == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A node that left keeps serving reads from its local data; only
+		// writes are rejected, since they could no longer be synchronized
+		if s.cluster.HasLeft() {
+			if isWriteMethod(r.Method) {
+				writeUnavailable(w, newError(http.StatusServiceUnavailable, CodeNodeLeftCluster, "Node has left the cluster, not accepting writes"))
+				return
+			}
+		} else if !s.cluster.IsReady() {
+			w.Header().Set("Retry-After", "1")
+			writeUnavailable(w, newError(http.StatusServiceUnavailable, CodeClusterNotReady, "Node is syncing, not ready yet"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWriteMethod returns true for HTTP methods that change todos
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// writeUnavailable writes a 503 problem response from middleware
+func writeUnavailable(w http.ResponseWriter, problem error) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// checkAcceptingWrites rejects writes once the node has left the cluster,
+// since they could no longer be synchronized to other nodes
+func (s *Server) checkAcceptingWrites() error {
+	if s.cluster != nil && s.cluster.HasLeft() {
+		return newError(http.StatusServiceUnavailable, CodeNodeLeftCluster, "Node has left the cluster, not accepting writes")
+	}
+	return nil
+}
+
+type ClusterLeaveRequest struct {
+	Authorization string `header:"Authorization" doc:"Bearer admin token"`
+}
+
+type ClusterLeaveResponse struct {
+	Body struct {
+		Left    bool   `json:"left" doc:"Whether the node has left the cluster"`
+		Message string `json:"message,omitempty" doc:"Optional status message"`
+	}
+}
+
+func (s *Server) clusterLeave(ctx context.Context, input *ClusterLeaveRequest) (*ClusterLeaveResponse, error) {
+	if err := s.checkAdmin(input.Authorization); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return nil, newError(http.StatusBadRequest, CodeStandaloneMode, "Running in standalone mode, no cluster to leave")
+	}
+
+	if err := s.cluster.Leave(); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to leave cluster", err)
+	}
+
+	resp := &ClusterLeaveResponse{}
+	resp.Body.Left = true
+	resp.Body.Message = "Node has left the cluster"
+	return resp, nil
+}
+
+type ClusterResyncRequest struct {
+	Authorization string `header:"Authorization" doc:"Bearer admin token"`
+}
+
+type ClusterResyncResponse struct {
+	Body struct {
+		CountBefore int `json:"count_before" doc:"Number of local todos before the sync"`
+		CountAfter  int `json:"count_after" doc:"Number of local todos after the sync"`
+		Synced      int `json:"synced" doc:"Todos created locally by the sync"`
+		Reconciled  int `json:"reconciled" doc:"Existing todos updated to the received state"`
+	}
+}
+
+func (s *Server) clusterResync(ctx context.Context, input *ClusterResyncRequest) (*ClusterResyncResponse, error) {
+	if err := s.checkAdmin(input.Authorization); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return nil, newError(http.StatusBadRequest, CodeStandaloneMode, "Running in standalone mode, no cluster to sync from")
+	}
+	if s.cluster.HasLeft() {
+		return nil, newError(http.StatusServiceUnavailable, CodeNodeLeftCluster, "Node has left the cluster")
+	}
+
+	before, err := s.db.CountTodos()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to count todos", err)
+	}
+
+	result, err := s.cluster.Resync()
+	if errors.Is(err, cluster.ErrSyncInProgress) {
+		return nil, newError(http.StatusConflict, CodeSyncInProgress, "A full sync is already in progress")
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to resync", err)
+	}
+
+	after, err := s.db.CountTodos()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to count todos", err)
+	}
+
+	resp := &ClusterResyncResponse{}
+	resp.Body.CountBefore = before
+	resp.Body.CountAfter = after
+	resp.Body.Synced = result.Synced
+	resp.Body.Reconciled = result.Reconciled
+	return resp, nil
+}
+
+type ClusterEventsRequest struct {
+	Limit  int `query:"limit" minimum:"1" maximum:"500" default:"50" doc:"Maximum number of events to return"`
+	Offset int `query:"offset" minimum:"0" default:"0" doc:"Number of events to skip"`
+}
+
+type ClusterEventsResponse struct {
+	Body struct {
+		Events []models.ClusterEvent `json:"events" doc:"Cluster membership events, newest first"`
+		Total  int                   `json:"total" doc:"Total number of recorded events"`
+	}
+}
+
+func (s *Server) clusterEvents(ctx context.Context, input *ClusterEventsRequest) (*ClusterEventsResponse, error) {
+	events, err := s.db.ListClusterEvents(input.Limit, input.Offset)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to list cluster events", err)
+	}
+
+	total, err := s.db.CountClusterEvents()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to count cluster events", err)
+	}
+
+	// Return empty array instead of nil
+	if events == nil {
+		events = []models.ClusterEvent{}
+	}
+
+	resp := &ClusterEventsResponse{}
+	resp.Body.Events = events
+	resp.Body.Total = total
+	return resp, nil
+}
+
+type ClusterTopologyResponse struct {
+	Body cluster.Topology
+}
+
+func (s *Server) clusterTopology(ctx context.Context, input *struct{}) (*ClusterTopologyResponse, error) {
+	if s.cluster == nil {
+		return nil, newError(http.StatusBadRequest, CodeStandaloneMode, "Running in standalone mode, no cluster topology")
+	}
+	return &ClusterTopologyResponse{Body: s.cluster.Topology()}, nil
+}
 
+type AdminBackupRequest struct {
+	Authorization string `header:"Authorization" doc:"Bearer admin token"`
+}
+
+type AdminBackupResponse struct {
+	Body struct {
+		Path      string `json:"path" doc:"Path of the backup file"`
+		SizeBytes int64  `json:"size_bytes" doc:"Size of the backup file in bytes"`
+	}
+}
+
+func (s *Server) adminBackup(ctx context.Context, input *AdminBackupRequest) (*AdminBackupResponse, error) {
+	if err := s.checkAdmin(input.Authorization); err != nil {
+		return nil, err
+	}
+
+	// Standalone servers have no cluster to ask for the node name
+	node := s.opts.NodeName
+	if node == "" {
+		node = "standalone"
+	}
+	name := fmt.Sprintf("%s-%s.db", node, time.Now().UTC().Format("20060102T150405Z"))
+	path := filepath.Join(s.opts.BackupDir, name)
+
+	if err := s.db.Backup(path); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to back up database", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to stat backup file", err)
+	}
+
+	log.Printf("💾 Database backed up to %s", path)
+
+	resp := &AdminBackupResponse{}
+	resp.Body.Path = path
+	resp.Body.SizeBytes = info.Size()
 	return resp, nil
 }
+
+type AdminVacuumRequest struct {
+	Authorization string `header:"Authorization" doc:"Bearer admin token"`
+}
+
+type AdminVacuumResponse struct {
+	Body struct {
+		ReclaimedBytes int64 `json:"reclaimed_bytes" doc:"Bytes the database file shrank by"`
+	}
+}
+
+func (s *Server) adminVacuum(ctx context.Context, input *AdminVacuumRequest) (*AdminVacuumResponse, error) {
+	if err := s.checkAdmin(input.Authorization); err != nil {
+		return nil, err
+	}
+
+	reclaimed, err := s.db.IncrementalVacuum()
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to vacuum database", err)
+	}
+
+	log.Printf("🧹 Database vacuumed, %d bytes reclaimed", reclaimed)
+
+	resp := &AdminVacuumResponse{}
+	resp.Body.ReclaimedBytes = reclaimed
+	return resp, nil
+}
+
+type AdminKeyringRequest struct {
+	Authorization string `header:"Authorization" doc:"Bearer admin token"`
+}
+
+type AdminKeyringResponse struct {
+	Body *cluster.KeyringStatus
+}
+
+func (s *Server) adminKeyring(ctx context.Context, input *AdminKeyringRequest) (*AdminKeyringResponse, error) {
+	if err := s.checkAdmin(input.Authorization); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return nil, newError(http.StatusBadRequest, CodeStandaloneMode, "Running in standalone mode, no keyring to list")
+	}
+
+	status, err := s.cluster.Keyring()
+	if err != nil {
+		return nil, keyringError("Failed to list keys", err)
+	}
+	return &AdminKeyringResponse{Body: status}, nil
+}
+
+type AdminKeyringRotateRequest struct {
+	Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	Body          struct {
+		Key string `json:"key" minLength:"1" doc:"New base64 encoded 16, 24 or 32 byte key"`
+	}
+}
+
+func (s *Server) adminKeyringRotate(ctx context.Context, input *AdminKeyringRotateRequest) (*AdminKeyringResponse, error) {
+	if err := s.checkAdmin(input.Authorization); err != nil {
+		return nil, err
+	}
+	if s.cluster == nil {
+		return nil, newError(http.StatusBadRequest, CodeStandaloneMode, "Running in standalone mode, no keyring to rotate")
+	}
+
+	status, err := s.cluster.RotateKey(input.Body.Key)
+	if err != nil {
+		return nil, keyringError("Failed to rotate key", err)
+	}
+	return &AdminKeyringResponse{Body: status}, nil
+}
+
+// keyringError maps keyring operation errors to responses
+func keyringError(msg string, err error) error {
+	switch {
+	case errors.Is(err, cluster.ErrEncryptionDisabled):
+		return newError(http.StatusConflict, CodeEncryptionDisabled, "Gossip encryption is not enabled, there is no keyring")
+	case errors.Is(err, cluster.ErrInvalidKey):
+		return huma.Error422UnprocessableEntity(err.Error())
+	}
+	return huma.Error500InternalServerError(msg, err)
+}
+
+// checkAdmin verifies the bearer token of an admin request
+func (s *Server) checkAdmin(authorization string) error {
+	if s.opts.AdminToken == "" {
+		return newError(http.StatusForbidden, CodeAdminDisabled, "Admin endpoints are disabled (no admin_token configured)")
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.AdminToken)) != 1 {
+		return newError(http.StatusUnauthorized, CodeUnauthorized, "Invalid or missing admin token")
+	}
+	return nil
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/cluster"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/danielgtaylor/huma/v2/humatest"
+)
+
+const testAdminToken = "secret"
+
+// fakeCluster is a Cluster recording the broadcasts of the API
+type fakeCluster struct {
+	mu         sync.Mutex
+	ready      bool
+	left       bool
+	alive      int
+	broadcasts []string
+
+	resyncResult cluster.SyncResult
+	resyncErr    error
+	resyncs      int
+
+	activity chan cluster.ActivityEvent
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{ready: true, alive: 1, activity: make(chan cluster.ActivityEvent, 16)}
+}
+
+func (f *fakeCluster) broadcast(event string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.broadcasts = append(f.broadcasts, event)
+	return nil
+}
+
+func (f *fakeCluster) BroadcastTodoCreated(todo *models.Todo) error {
+	return f.broadcast("created " + todo.Namespace + "/" + todo.ExternID)
+}
+
+func (f *fakeCluster) BroadcastTodoUpdated(todo *models.Todo) error {
+	return f.broadcast("updated " + todo.Namespace + "/" + todo.ExternID)
+}
+
+func (f *fakeCluster) BroadcastTodoDeleted(namespace, externID string) error {
+	return f.broadcast("deleted " + namespace + "/" + externID)
+}
+
+// Broadcasts returns the broadcasts so far
+func (f *fakeCluster) Broadcasts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.broadcasts...)
+}
+
+func (f *fakeCluster) FetchTodo(namespace, externID string) (*models.Todo, error) { return nil, nil }
+func (f *fakeCluster) CheckTodoSize(todo *models.Todo) error                      { return nil }
+func (f *fakeCluster) IsReady() bool                                              { return f.ready && !f.left }
+func (f *fakeCluster) LocalNode() string                                          { return "node-a" }
+func (f *fakeCluster) MemberCount() int                                           { return f.alive }
+func (f *fakeCluster) AliveMemberCount() int                                      { return f.alive }
+func (f *fakeCluster) GetMemberInfo() []models.ClusterMemberInfo                  { return nil }
+func (f *fakeCluster) HasLeft() bool                                              { return f.left }
+func (f *fakeCluster) LastSyncAt() time.Time                                      { return time.Time{} }
+func (f *fakeCluster) SyncSilent() bool                                           { return false }
+func (f *fakeCluster) Topology() cluster.Topology                                 { return cluster.Topology{} }
+
+func (f *fakeCluster) Leave() error {
+	f.left = true
+	return nil
+}
+
+func (f *fakeCluster) Resync() (cluster.SyncResult, error) {
+	f.resyncs++
+	return f.resyncResult, f.resyncErr
+}
+
+func (f *fakeCluster) Subscribe() (<-chan cluster.ActivityEvent, func()) {
+	return f.activity, func() {}
+}
+
+func (f *fakeCluster) Keyring() (*cluster.KeyringStatus, error) {
+	return nil, cluster.ErrEncryptionDisabled
+}
+
+func (f *fakeCluster) RotateKey(key string) (*cluster.KeyringStatus, error) {
+	return nil, cluster.ErrEncryptionDisabled
+}
+
+// newTestAPI serves the routes of a server with a fresh database and the
+// given cluster, which may be nil for a standalone server
+func newTestAPI(t *testing.T, c Cluster, opts Options) (humatest.TestAPI, *database.DB) {
+	t.Helper()
+	_, api, db := newTestServer(t, c, opts)
+	return api, db
+}
+
+// newTestServer is like newTestAPI, but also returns the server for
+// testing its middlewares
+func newTestServer(t *testing.T, c Cluster, opts Options) (*Server, humatest.TestAPI, *database.DB) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "todos.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if opts.AdminToken == "" {
+		opts.AdminToken = testAdminToken
+	}
+	if opts.NodeName == "" {
+		opts.NodeName = "node-a"
+	}
+	_, api := humatest.New(t)
+	server := NewServer(db, c, opts)
+	server.RegisterRoutes(api)
+	return server, api, db
+}
+
+const adminAuth = "Authorization: Bearer " + testAdminToken
+
+func TestClusterLeaveRequiresAdmin(t *testing.T) {
+	c := newFakeCluster()
+	api, _ := newTestAPI(t, c, Options{})
+
+	if resp := api.Post("/cluster/leave"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("leave without token = %d, want %d", resp.Code, http.StatusUnauthorized)
+	}
+	if resp := api.Post("/cluster/leave", "Authorization: Bearer wrong"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("leave with wrong token = %d, want %d", resp.Code, http.StatusUnauthorized)
+	}
+	if c.HasLeft() {
+		t.Fatal("node left without a valid admin token")
+	}
+
+	if resp := api.Post("/cluster/leave", adminAuth); resp.Code != http.StatusOK {
+		t.Fatalf("leave = %d: %s", resp.Code, resp.Body)
+	}
+	if !c.HasLeft() {
+		t.Error("node did not leave")
+	}
+}
+
+func TestHealthReadyReportsLeftNode(t *testing.T) {
+	c := newFakeCluster()
+	api, _ := newTestAPI(t, c, Options{})
+	if resp := api.Get("/health/ready"); resp.Code != http.StatusOK {
+		t.Fatalf("ready = %d: %s", resp.Code, resp.Body)
+	}
+
+	api.Post("/cluster/leave", adminAuth)
+	resp := api.Get("/health/ready")
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("ready after leaving = %d, want %d", resp.Code, http.StatusServiceUnavailable)
+	}
+	if body := resp.Body.String(); !strings.Contains(body, CodeNodeLeftCluster) {
+		t.Errorf("ready after leaving = %s, want code %s", body, CodeNodeLeftCluster)
+	}
+}
+
+func TestHealthReadyCriteria(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		setup func(c *fakeCluster, db *database.DB)
+		code  string // empty when ready
+	}{
+		{
+			name: "all met",
+			opts: Options{MinMembers: 2, CheckDBWritable: true},
+			setup: func(c *fakeCluster, db *database.DB) {
+				c.alive = 2
+			},
+		},
+		{
+			name: "too few members",
+			opts: Options{MinMembers: 2, CheckDBWritable: true},
+			setup: func(c *fakeCluster, db *database.DB) {
+				c.alive = 1
+			},
+			code: CodeTooFewMembers,
+		},
+		{
+			name: "initial sync pending",
+			opts: Options{MinMembers: 2, CheckDBWritable: true},
+			setup: func(c *fakeCluster, db *database.DB) {
+				c.alive = 2
+				c.ready = false
+			},
+			code: CodeClusterNotReady,
+		},
+		{
+			name: "database not writable",
+			opts: Options{MinMembers: 2, CheckDBWritable: true},
+			setup: func(c *fakeCluster, db *database.DB) {
+				c.alive = 2
+				db.Close()
+			},
+			code: CodeDatabaseNotWritable,
+		},
+		{
+			name: "database check disabled",
+			opts: Options{MinMembers: 2},
+			setup: func(c *fakeCluster, db *database.DB) {
+				c.alive = 2
+				db.Close()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeCluster()
+			api, db := newTestAPI(t, c, tt.opts)
+			tt.setup(c, db)
+
+			resp := api.Get("/health/ready")
+			if tt.code == "" {
+				if resp.Code != http.StatusOK {
+					t.Errorf("ready = %d: %s, want 200", resp.Code, resp.Body)
+				}
+				return
+			}
+			if resp.Code != http.StatusServiceUnavailable || !strings.Contains(resp.Body.String(), tt.code) {
+				t.Errorf("ready = %d: %s, want 503 with code %s", resp.Code, resp.Body, tt.code)
+			}
+		})
+	}
+}
+
+func TestClusterResync(t *testing.T) {
+	c := newFakeCluster()
+	c.resyncResult = cluster.SyncResult{Synced: 2, Reconciled: 1}
+	api, db := newTestAPI(t, c, Options{})
+	if _, err := db.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-a", nil, nil); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	if resp := api.Post("/cluster/resync"); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("resync without token = %d, want %d", resp.Code, http.StatusUnauthorized)
+	}
+	if c.resyncs != 0 {
+		t.Fatal("resync ran without a valid admin token")
+	}
+
+	resp := api.Post("/cluster/resync", adminAuth)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("resync = %d: %s", resp.Code, resp.Body)
+	}
+	var body ClusterResyncResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &body.Body); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if c.resyncs != 1 {
+		t.Errorf("%d syncs run, want 1", c.resyncs)
+	}
+	if body.Body.CountBefore != 1 || body.Body.CountAfter != 1 || body.Body.Synced != 2 || body.Body.Reconciled != 1 {
+		t.Errorf("resync = %+v, want counts 1/1, synced 2, reconciled 1", body.Body)
+	}
+
+	c.resyncErr = cluster.ErrSyncInProgress
+	if resp := api.Post("/cluster/resync", adminAuth); resp.Code != http.StatusConflict {
+		t.Errorf("resync while syncing = %d, want %d", resp.Code, http.StatusConflict)
+	}
+}
+
+func TestTodosExpireAfterCreationOnEveryNode(t *testing.T) {
+	c := newFakeCluster()
+	api, _ := newTestAPI(t, c, Options{TTLAfterCreation: time.Hour})
+
+	expiresAt := func(resp *httptest.ResponseRecorder) time.Time {
+		t.Helper()
+		var todo models.Todo
+		if err := json.Unmarshal(resp.Body.Bytes(), &todo); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if todo.ExpiresAt == nil {
+			t.Fatalf("todo %s has no expires_at, want one from the TTL", todo.ExternID)
+		}
+		return *todo.ExpiresAt
+	}
+
+	before := time.Now()
+	resp := api.Post("/todos", map[string]any{"extern_id": "todo-1", "todo": "Buy milk"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("create = %d: %s", resp.Code, resp.Body)
+	}
+	if got := expiresAt(resp); got.Before(before.Add(time.Hour).Truncate(time.Second)) || got.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expires_at = %v, want an hour after creation", got)
+	}
+
+	// An earlier requested expiry is kept
+	requested := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	resp = api.Post("/todos", map[string]any{"extern_id": "todo-2", "todo": "Buy milk", "expires_at": requested})
+	if got := expiresAt(resp); !got.Equal(requested) {
+		t.Errorf("expires_at = %v, want the requested %v", got, requested)
+	}
+
+	resp = api.Put("/todos/by-extern/todo-3", map[string]any{"todo": "Buy milk"})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("put = %d: %s", resp.Code, resp.Body)
+	}
+	expiresAt(resp)
+}
+
+func TestUpdateTodoIfMatch(t *testing.T) {
+	api, _ := newTestAPI(t, newFakeCluster(), Options{})
+	resp := api.Post("/todos", map[string]any{"extern_id": "todo-1", "todo": "Buy milk"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("create = %d: %s", resp.Code, resp.Body)
+	}
+	etag := api.Get("/todos/1").Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("ETag = %s, want \"1\"", etag)
+	}
+
+	// Matching version
+	resp = api.Put("/todos/1", "If-Match: "+etag, map[string]any{"todo": "Buy oat milk"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("update with matching If-Match = %d: %s", resp.Code, resp.Body)
+	}
+	if got := resp.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("ETag after update = %s, want \"2\"", got)
+	}
+
+	// Stale version, e.g. from a client that read before the update above
+	for _, ifMatch := range []string{etag, "W/" + etag, "1"} {
+		resp = api.Put("/todos/1", "If-Match: "+ifMatch, map[string]any{"todo": "Buy soy milk"})
+		if resp.Code != http.StatusPreconditionFailed || !strings.Contains(resp.Body.String(), CodeVersionMismatch) {
+			t.Errorf("update with stale If-Match %s = %d: %s, want 412 %s", ifMatch, resp.Code, resp.Body, CodeVersionMismatch)
+		}
+	}
+	if resp := api.Delete("/todos/1", "If-Match: "+etag); resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete with stale If-Match = %d, want 412", resp.Code)
+	}
+	if resp := api.Put("/todos/1", "If-Match: not-a-version", map[string]any{"todo": "Buy soy milk"}); resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("update with invalid If-Match = %d, want 412", resp.Code)
+	}
+
+	var todo models.Todo
+	if err := json.Unmarshal(api.Get("/todos/1").Body.Bytes(), &todo); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if todo.Todo != "Buy oat milk" || todo.Version != 2 {
+		t.Errorf("todo = %q at version %d, want %q at version 2", todo.Todo, todo.Version, "Buy oat milk")
+	}
+}
+
+func TestPutTodoByExternID(t *testing.T) {
+	c := newFakeCluster()
+	api, _ := newTestAPI(t, c, Options{})
+
+	decode := func(resp *httptest.ResponseRecorder) models.Todo {
+		t.Helper()
+		var todo models.Todo
+		if err := json.Unmarshal(resp.Body.Bytes(), &todo); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		return todo
+	}
+
+	// Create branch
+	resp := api.Put("/todos/by-extern/todo-1", map[string]any{"todo": "Buy milk"})
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("put of a new todo = %d: %s, want 201", resp.Code, resp.Body)
+	}
+	created := decode(resp)
+	if created.Todo != "Buy milk" || created.Completed || created.OriginNode != "node-a" {
+		t.Errorf("created todo = %+v", created)
+	}
+
+	// Update branch: keeps the id, changes the text and completed state
+	resp = api.Put("/todos/by-extern/todo-1", map[string]any{"todo": "Buy oat milk", "completed": true})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("put of an existing todo = %d: %s, want 200", resp.Code, resp.Body)
+	}
+	updated := decode(resp)
+	if updated.ID != created.ID || updated.Todo != "Buy oat milk" || !updated.Completed || updated.Version != created.Version+1 {
+		t.Errorf("updated todo = %+v, want todo %d with the new text, completed, at version %d", updated, created.ID, created.Version+1)
+	}
+	if got := resp.Header().Get("ETag"); got != formatETag(updated.Version) {
+		t.Errorf("ETag = %s, want %s", got, formatETag(updated.Version))
+	}
+
+	// Omitting completed leaves it unchanged
+	if updated := decode(api.Put("/todos/by-extern/todo-1", map[string]any{"todo": "Buy soy milk"})); !updated.Completed {
+		t.Error("put without completed reopened the todo")
+	}
+
+	want := []string{"created default/todo-1", "updated default/todo-1", "updated default/todo-1"}
+	if got := c.Broadcasts(); !slices.Equal(got, want) {
+		t.Errorf("broadcasts = %v, want %v", got, want)
+	}
+}
+
+func TestNamespacesAreIsolated(t *testing.T) {
+	c := newFakeCluster()
+	api, _ := newTestAPI(t, c, Options{})
+
+	decode := func(resp *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if err := json.Unmarshal(resp.Body.Bytes(), v); err != nil {
+			t.Fatalf("Unmarshal: %v: %s", err, resp.Body)
+		}
+	}
+
+	// The same extern_id may exist once per namespace
+	ids := make(map[string]int)
+	for _, ns := range []string{"team-a", "team-b"} {
+		resp := api.Post("/todos", "X-Namespace: "+ns, map[string]any{"extern_id": "todo-1", "todo": "Buy milk for " + ns})
+		if resp.Code != http.StatusOK {
+			t.Fatalf("create in %s = %d: %s", ns, resp.Code, resp.Body)
+		}
+		var todo models.Todo
+		decode(resp, &todo)
+		if todo.Namespace != ns {
+			t.Errorf("todo created in %s has namespace %q", ns, todo.Namespace)
+		}
+		ids[ns] = todo.ID
+	}
+
+	for _, ns := range []string{"team-a", "team-b"} {
+		var todos []models.Todo
+		decode(api.Get("/todos", "X-Namespace: "+ns), &todos)
+		if len(todos) != 1 || todos[0].ID != ids[ns] {
+			t.Errorf("list in %s = %+v, want only todo %d", ns, todos, ids[ns])
+		}
+
+		var todo models.Todo
+		decode(api.Get("/todos/by-extern/todo-1", "X-Namespace: "+ns), &todo)
+		if todo.Todo != "Buy milk for "+ns {
+			t.Errorf("todo-1 in %s = %q", ns, todo.Todo)
+		}
+	}
+	var todos []models.Todo
+	decode(api.Get("/todos"), &todos)
+	if len(todos) != 0 {
+		t.Errorf("list in the default namespace = %+v, want none", todos)
+	}
+
+	// Todos of another namespace cannot be read, changed or deleted by id
+	other := "X-Namespace: team-b"
+	path := "/todos/" + strconv.Itoa(ids["team-a"])
+	if resp := api.Get(path, other); resp.Code != http.StatusNotFound {
+		t.Errorf("get from another namespace = %d, want 404", resp.Code)
+	}
+	if resp := api.Put(path, other, map[string]any{"todo": "Buy oat milk"}); resp.Code != http.StatusNotFound {
+		t.Errorf("update from another namespace = %d, want 404", resp.Code)
+	}
+	if resp := api.Delete(path, other); resp.Code != http.StatusNotFound {
+		t.Errorf("delete from another namespace = %d, want 404", resp.Code)
+	}
+
+	// Deleting the todo in one namespace keeps the other's
+	if resp := api.Delete("/todos/"+strconv.Itoa(ids["team-b"]), other); resp.Code != http.StatusNoContent {
+		t.Fatalf("delete in team-b = %d: %s", resp.Code, resp.Body)
+	}
+	var todo models.Todo
+	decode(api.Get(path, "X-Namespace: team-a"), &todo)
+	if todo.Todo != "Buy milk for team-a" {
+		t.Errorf("todo in team-a after deleting team-b's = %+v", todo)
+	}
+
+	want := []string{"created team-a/todo-1", "created team-b/todo-1", "deleted team-b/todo-1"}
+	if got := c.Broadcasts(); !slices.Equal(got, want) {
+		t.Errorf("broadcasts = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// CSVFormat renders todo lists as CSV. Register it in the Huma config under
+// "text/csv" so clients can request it via the Accept header.
+var CSVFormat = huma.Format{
+	Marshal:   marshalCSV,
+	Unmarshal: unmarshalCSV,
+}
+
+// csvHeader lists the exported columns
+var csvHeader = []string{"id", "extern_id", "todo", "completed", "version", "created_at"}
+
+// marshalCSV writes todos as CSV with a header row, one row at a time.
+// Other values (e.g. error responses) are written as JSON.
+func marshalCSV(w io.Writer, v any) error {
+	var todos []models.Todo
+	switch body := v.(type) {
+	case []models.Todo:
+		todos = body
+	case *[]models.Todo:
+		todos = *body
+	default:
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		row := []string{
+			strconv.Itoa(todo.ID),
+			todo.ExternID,
+			todo.Todo,
+			strconv.FormatBool(todo.Completed),
+			strconv.Itoa(todo.Version),
+			todo.CreatedAt.Format(time.RFC3339Nano),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// unmarshalCSV rejects CSV request bodies
+func unmarshalCSV(data []byte, v any) error {
+	return errors.New("CSV request bodies are not supported")
+}
+
+// csvQueryMiddleware lets clients request CSV via ?format=csv in addition
+// to the Accept header
+func csvQueryMiddleware(ctx huma.Context, next func(huma.Context)) {
+	if strings.EqualFold(ctx.Query("format"), "csv") {
+		ctx = &acceptContext{humaContext: ctx, accept: "text/csv"}
+	}
+	next(ctx)
+}
+
+// humaContext aliases huma.Context so it can be embedded without its
+// field name clashing with the Context() method
+type humaContext = huma.Context
+
+// acceptContext overrides the Accept header of a Huma context
+type acceptContext struct {
+	humaContext
+	accept string
+}
+
+// Header returns the overridden Accept header or the original header value
+func (c *acceptContext) Header(name string) string {
+	if strings.EqualFold(name, "Accept") {
+		return c.accept
+	}
+	return c.humaContext.Header(name)
+}
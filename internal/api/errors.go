@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Stable machine-readable error codes returned in the "code" field of
+// error responses. Clients should branch on these instead of messages.
+const (
+	CodeTodoNotFound        = "TODO_NOT_FOUND"
+	CodeExternIDConflict    = "EXTERN_ID_CONFLICT"
+	CodeTodoCompleted       = "TODO_COMPLETED"
+	CodeTodoTooLarge        = "TODO_TOO_LARGE"
+	CodeVersionMismatch     = "VERSION_MISMATCH"
+	CodeInvalidPrecondition = "INVALID_PRECONDITION"
+	CodeClusterNotReady     = "CLUSTER_NOT_READY"
+	CodeNodeLeftCluster     = "NODE_LEFT_CLUSTER"
+	CodeStandaloneMode      = "STANDALONE_MODE"
+	CodeSyncInProgress      = "SYNC_IN_PROGRESS"
+	CodeShuttingDown        = "SHUTTING_DOWN"
+	CodeSyncSilent          = "SYNC_SILENT"
+	CodeTooFewMembers       = "TOO_FEW_MEMBERS"
+	CodeDatabaseNotWritable = "DATABASE_NOT_WRITABLE"
+	CodeRequestTimeout      = "REQUEST_TIMEOUT"
+	CodeInvalidPatch        = "INVALID_PATCH"
+	CodePatchTestFailed     = "PATCH_TEST_FAILED"
+	CodeUnauthorized        = "UNAUTHORIZED"
+	CodeAdminDisabled       = "ADMIN_DISABLED"
+	CodeEncryptionDisabled  = "ENCRYPTION_DISABLED"
+	CodeInternalError       = "INTERNAL_ERROR"
+)
+
+// ErrorModel extends Huma's error model with a stable error code
+type ErrorModel struct {
+	huma.ErrorModel
+	Code string `json:"code,omitempty" doc:"Machine-readable error code"`
+}
+
+// defaultNewError is Huma's original error constructor
+var defaultNewError = huma.NewError
+
+func init() {
+	// Make every error response, including Huma's own validation errors,
+	// carry a code. Errors without a specific code get one derived from
+	// the HTTP status (e.g. NOT_FOUND, VALIDATION_FAILED).
+	huma.NewError = func(status int, msg string, errs ...error) huma.StatusError {
+		model, ok := defaultNewError(status, msg, errs...).(*huma.ErrorModel)
+		if !ok {
+			return defaultNewError(status, msg, errs...)
+		}
+		return &ErrorModel{ErrorModel: *model, Code: defaultCode(status)}
+	}
+}
+
+// defaultCode derives an error code from an HTTP status
+func defaultCode(status int) string {
+	switch status {
+	case http.StatusUnprocessableEntity:
+		return "VALIDATION_FAILED"
+	case http.StatusInternalServerError:
+		return CodeInternalError
+	}
+	return strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// newError creates an error response with the given status and code
+func newError(status int, code, msg string, errs ...error) huma.StatusError {
+	err := huma.NewError(status, msg, errs...)
+	if model, ok := err.(*ErrorModel); ok {
+		model.Code = code
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// jsonPatchContentType is the media type for RFC 6902 JSON Patch documents
+const jsonPatchContentType = "application/json-patch+json"
+
+// patchOperation is a single RFC 6902 JSON Patch operation
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// patchableFields are the todo fields that may be changed via JSON Patch
+var patchableFields = map[string]string{
+	"/todo":      "todo",
+	"/completed": "completed",
+}
+
+// JSONPatchMiddleware adds RFC 6902 JSON Patch support to PUT /todos/{id}.
+// A request with Content-Type application/json-patch+json is applied to the
+// current todo and rewritten into a regular JSON update, so the result is
+// validated by the update operation like any other update. The update is
+// made conditional on the version the patch was applied to, so a
+// concurrent change fails it instead of slipping past test operations.
+func (s *Server) JSONPatchMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if r.Method != http.MethodPut || mediaType != jsonPatchContentType {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/todos/"))
+		if !strings.HasPrefix(r.URL.Path, "/todos/") || err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var ops []patchOperation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			writePatchError(w, http.StatusBadRequest, "Invalid JSON Patch document: "+err.Error())
+			return
+		}
+
+		todo, err := s.db.GetTodo(id)
+		if err != nil {
+			writePatchError(w, http.StatusInternalServerError, "Failed to get todo")
+			return
+		}
+
+		// Todos in other namespaces are not visible to this request
+		namespace := r.Header.Get("X-Namespace")
+		if namespace == "" {
+			namespace = models.DefaultNamespace
+		}
+		if todo != nil && todo.Namespace != namespace {
+			todo = nil
+		}
+
+		// Unknown todos are passed on as an empty update so the handler returns 404
+		update := map[string]any{}
+		if todo != nil {
+			doc := map[string]any{
+				"todo":      todo.Todo,
+				"completed": todo.Completed,
+			}
+			if status, msg := applyPatch(doc, ops); status != 0 {
+				writePatchError(w, status, msg)
+				return
+			}
+			for _, field := range patchableFields {
+				update[field] = doc[field]
+			}
+
+			// A client precondition is kept; it fails the update anyway
+			// unless it matches the version read here
+			if ifMatch := r.Header.Get("If-Match"); ifMatch == "" || ifMatch == "*" {
+				r.Header.Set("If-Match", formatETag(todo.Version))
+			}
+		}
+
+		body, err := json.Marshal(update)
+		if err != nil {
+			writePatchError(w, http.StatusInternalServerError, "Failed to encode patched todo")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", "application/json")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// applyPatch applies JSON Patch operations to doc in place. It returns a
+// non-zero HTTP status and message if an operation is invalid or a test fails.
+func applyPatch(doc map[string]any, ops []patchOperation) (int, string) {
+	for i, op := range ops {
+		field, ok := patchableFields[op.Path]
+		if !ok {
+			return http.StatusUnprocessableEntity, fmt.Sprintf("Operation %d: path %q is not patchable", i, op.Path)
+		}
+
+		var value any
+		if op.Op == "test" || op.Op == "replace" || op.Op == "add" {
+			if len(op.Value) == 0 {
+				return http.StatusUnprocessableEntity, fmt.Sprintf("Operation %d: missing value", i)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return http.StatusUnprocessableEntity, fmt.Sprintf("Operation %d: invalid value", i)
+			}
+		}
+
+		switch op.Op {
+		case "test":
+			if !reflect.DeepEqual(doc[field], value) {
+				return http.StatusConflict, fmt.Sprintf("Operation %d: test failed for %s", i, op.Path)
+			}
+		case "replace", "add":
+			// Both fields always exist, so add behaves like replace
+			doc[field] = value
+		default:
+			return http.StatusUnprocessableEntity, fmt.Sprintf("Operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+	return 0, ""
+}
+
+// writePatchError writes an error in the same format as Huma errors
+func writePatchError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	code := CodeInvalidPatch
+	switch status {
+	case http.StatusConflict:
+		code = CodePatchTestFailed
+	case http.StatusInternalServerError:
+		code = CodeInternalError
+	}
+	json.NewEncoder(w).Encode(newError(status, code, msg))
+}
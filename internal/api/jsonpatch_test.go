@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestJSONPatch(t *testing.T) {
+	c := newFakeCluster()
+	server, api, _ := newTestServer(t, c, Options{})
+	handler := server.JSONPatchMiddleware(api.Adapter())
+	if resp := api.Post("/todos", map[string]any{"extern_id": "todo-1", "todo": "Buy milk"}); resp.Code != http.StatusOK {
+		t.Fatalf("create = %d: %s", resp.Code, resp.Body)
+	}
+
+	patch := func(doc string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/todos/1", strings.NewReader(doc))
+		req.Header.Set("Content-Type", jsonPatchContentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	get := func() models.Todo {
+		var todo models.Todo
+		if err := json.Unmarshal(api.Get("/todos/1").Body.Bytes(), &todo); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		return todo
+	}
+
+	tests := []struct {
+		name       string
+		doc        string
+		wantStatus int
+		wantTodo   string
+		wantDone   bool
+	}{
+		{"replace", `[{"op": "replace", "path": "/todo", "value": "Buy oat milk"}]`, http.StatusOK, "Buy oat milk", false},
+		{"passing test", `[{"op": "test", "path": "/todo", "value": "Buy oat milk"}, {"op": "replace", "path": "/completed", "value": true}]`, http.StatusOK, "Buy oat milk", true},
+		{"failing test", `[{"op": "test", "path": "/completed", "value": false}, {"op": "replace", "path": "/todo", "value": "Buy soy milk"}]`, http.StatusConflict, "Buy oat milk", true},
+		{"immutable field", `[{"op": "replace", "path": "/extern_id", "value": "todo-2"}]`, http.StatusUnprocessableEntity, "Buy oat milk", true},
+		{"invalid result", `[{"op": "replace", "path": "/todo", "value": ""}]`, http.StatusUnprocessableEntity, "Buy oat milk", true},
+		{"unsupported op", `[{"op": "remove", "path": "/todo"}]`, http.StatusUnprocessableEntity, "Buy oat milk", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := patch(tt.doc); w.Code != tt.wantStatus {
+				t.Fatalf("patch = %d: %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if todo := get(); todo.Todo != tt.wantTodo || todo.Completed != tt.wantDone {
+				t.Errorf("todo = %q completed %v, want %q completed %v", todo.Todo, todo.Completed, tt.wantTodo, tt.wantDone)
+			}
+		})
+	}
+
+	// Applied patches are synchronized like any other update
+	if got := c.Broadcasts(); len(got) != 3 {
+		t.Errorf("broadcasts = %v, want the create and two updates", got)
+	}
+}
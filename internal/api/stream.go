@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	// streamFlushEvery is the number of todos written between flushes
+	streamFlushEvery = 100
+
+	// streamWriteTimeout bounds writing a batch of streamFlushEvery todos.
+	// It replaces the server's write timeout, which would cut off exports
+	// that take longer in the middle of the array.
+	streamWriteTimeout = 10 * time.Second
+)
+
+type StreamTodosRequest struct {
+	ListFilter
+}
+
+func (s *Server) streamTodos(ctx context.Context, input *StreamTodosRequest) (*huma.StreamResponse, error) {
+	opts, err := input.options()
+	if err != nil {
+		return nil, err
+	}
+
+	return &huma.StreamResponse{
+		Body: func(hctx huma.Context) {
+			hctx.SetHeader("Content-Type", "application/json")
+			hctx.SetStatus(http.StatusOK)
+			w := hctx.BodyWriter()
+
+			// Once the status is sent errors can't be reported, so a failed
+			// stream ends without the closing bracket and is invalid JSON
+			if err := writeTodoArray(w, func(fn func(*models.Todo) error) error {
+				return s.db.EachTodo(opts, fn)
+			}); err != nil {
+				log.Printf("❌ Failed to stream todos: %v", err)
+			}
+		},
+	}, nil
+}
+
+// writeTodoArray writes the todos produced by each as a JSON array, one
+// todo at a time, flushing regularly if the writer supports it. For
+// response writers the write deadline is extended with every batch.
+func writeTodoArray(w io.Writer, each func(fn func(*models.Todo) error) error) error {
+	flusher, _ := w.(http.Flusher)
+	extendDeadline := func() {}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		rc := http.NewResponseController(rw)
+		extendDeadline = func() {
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		}
+	}
+
+	extendDeadline()
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	n := 0
+	err := each(func(todo *models.Todo) error {
+		if n > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(todo)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+
+		n++
+		if n%streamFlushEvery == 0 {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			extendDeadline()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
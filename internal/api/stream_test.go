@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// measuringWriter is a response writer that discards the body, counting
+// the todos, and measures the live heap whenever the stream flushes
+type measuringWriter struct {
+	header   http.Header
+	code     int
+	todos    int
+	last     byte
+	peakHeap uint64
+}
+
+func (w *measuringWriter) Header() http.Header  { return w.header }
+func (w *measuringWriter) WriteHeader(code int) { w.code = code }
+
+func (w *measuringWriter) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte("{")) {
+		w.todos++
+	}
+	if len(p) > 0 {
+		w.last = p[len(p)-1]
+	}
+	return len(p), nil
+}
+
+func (w *measuringWriter) Flush() {
+	w.peakHeap = max(w.peakHeap, liveHeap())
+}
+
+// liveHeap returns the bytes of reachable heap objects
+func liveHeap() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func TestStreamTodosDoesNotLoadAllRows(t *testing.T) {
+	const count = 1000
+	api, db := newTestAPI(t, nil, Options{})
+
+	text := strings.Repeat("x", models.MaxTodoLength)
+	// The largest todos the API accepts, to keep the row count down
+	metadata := make(models.Metadata, models.MaxMetadataEntries)
+	for i := 0; i < models.MaxMetadataEntries; i++ {
+		metadata[fmt.Sprintf("key-%d", i)] = strings.Repeat("y", models.MaxMetadataValueLength)
+	}
+	for i := 0; i < count; i++ {
+		if _, err := db.CreateTodo(models.DefaultNamespace, fmt.Sprintf("todo-%d", i), text, "node-a", metadata, nil); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+	// Roughly what the rows take once loaded
+	datasetSize := uint64(count * (len(text) + models.MaxMetadataEntries*models.MaxMetadataValueLength))
+
+	w := &measuringWriter{header: make(http.Header)}
+	baseline := liveHeap()
+	api.Adapter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/stream", nil))
+
+	if w.code != http.StatusOK || w.todos != count || w.last != ']' {
+		t.Fatalf("stream = %d with %d todos ending in %q, want 200 with %d todos ending in ']'", w.code, w.todos, w.last, count)
+	}
+	if w.peakHeap == 0 {
+		t.Fatal("stream was never flushed")
+	}
+	// A slice would keep all rows alive at once, the stream only one batch
+	if grown := int64(w.peakHeap) - int64(baseline); grown > int64(datasetSize/8) {
+		t.Errorf("heap grew by %d bytes while streaming a %d byte dataset", grown, datasetSize)
+	}
+}
@@ -0,0 +1,56 @@
+package cluster
+
+import "time"
+
+// Activity categories
+const (
+	ActivityMember = "member" // membership changes (join, leave, failed, update, reap)
+	ActivitySync   = "sync"   // todo changes from peers applied locally (created, updated, deleted)
+)
+
+// activityBuffer is the number of events buffered per subscriber. Events for
+// subscribers that fall further behind are dropped.
+const activityBuffer = 64
+
+// ActivityEvent is a live notification about cluster activity
+type ActivityEvent struct {
+	Category  string    `json:"category" doc:"Event category (member, sync)"`
+	Type      string    `json:"type" doc:"Event type, e.g. join or failed for members and created for sync"`
+	Node      string    `json:"node" doc:"Member the event is about, or the node that sent the change"`
+	Addr      string    `json:"addr,omitempty" doc:"Member address"`
+	Namespace string    `json:"namespace,omitempty" doc:"Namespace of the changed todo"`
+	ExternID  string    `json:"extern_id,omitempty" doc:"External ID of the changed todo"`
+	Time      time.Time `json:"time" doc:"When the event was observed on this node"`
+}
+
+// Subscribe returns a channel receiving cluster activity until the returned
+// cancel function is called
+func (c *Cluster) Subscribe() (<-chan ActivityEvent, func()) {
+	ch := make(chan ActivityEvent, activityBuffer)
+
+	c.subscribersMu.Lock()
+	c.subscribers[ch] = struct{}{}
+	c.subscribersMu.Unlock()
+
+	cancel := func() {
+		c.subscribersMu.Lock()
+		delete(c.subscribers, ch)
+		c.subscribersMu.Unlock()
+	}
+	return ch, cancel
+}
+
+// publish sends an event to all subscribers without blocking event handling
+func (c *Cluster) publish(event ActivityEvent) {
+	event.Time = time.Now()
+
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+
+	for ch := range c.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
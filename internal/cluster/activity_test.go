@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+func TestMemberJoinIsPublished(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+
+	events, cancel := c.Subscribe()
+	c.handleMemberEvent(serf.MemberEvent{
+		Type:    serf.EventMemberJoin,
+		Members: []serf.Member{{Name: "node-b", Addr: net.ParseIP("10.0.0.2")}},
+	})
+
+	select {
+	case event := <-events:
+		if event.Category != ActivityMember || event.Type != "join" || event.Node != "node-b" || event.Addr != "10.0.0.2" || event.Time.IsZero() {
+			t.Errorf("event = %+v, want node-b joining from 10.0.0.2", event)
+		}
+	default:
+		t.Fatal("member join was not published")
+	}
+
+	// Cancelled subscribers get no more events
+	cancel()
+	c.handleMemberEvent(serf.MemberEvent{
+		Type:    serf.EventMemberFailed,
+		Members: []serf.Member{{Name: "node-b", Addr: net.ParseIP("10.0.0.2")}},
+	})
+	select {
+	case event := <-events:
+		t.Errorf("cancelled subscriber received %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ifacePrefix marks a bind address that names a network interface,
+// e.g. "iface:eth0:7946"
+const ifacePrefix = "iface:"
+
+// parseBindAddr splits a bind address into host and port. Besides
+// "IP:Port" it accepts "iface:<name>:<port>", which binds to the address
+// of the named interface.
+func parseBindAddr(bindAddr string) (string, int, error) {
+	var host, portStr string
+
+	if rest, ok := strings.CutPrefix(bindAddr, ifacePrefix); ok {
+		i := strings.LastIndex(rest, ":")
+		if i <= 0 {
+			return "", 0, fmt.Errorf("invalid bind address %q: expected iface:<name>:<port>", bindAddr)
+		}
+
+		addr, err := resolveInterfaceAddr(rest[:i])
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid bind address %q: %w", bindAddr, err)
+		}
+		host, portStr = addr, rest[i+1:]
+	} else {
+		var err error
+		host, portStr, err = net.SplitHostPort(bindAddr)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid bind address %q: %w", bindAddr, err)
+		}
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in bind address %q: %w", bindAddr, err)
+	}
+
+	return host, port, nil
+}
+
+// resolveInterfaceAddr returns the first IPv4 address of the interface, or
+// its first IPv6 address if it has no IPv4 address
+func resolveInterfaceAddr(name string) (string, error) {
+	addrs, err := interfaceAddrs(name)
+	if err != nil {
+		return "", err
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4.String(), nil
+		}
+		if ipv6 == nil && !ipNet.IP.IsLinkLocalUnicast() {
+			ipv6 = ipNet.IP
+		}
+	}
+
+	if ipv6 != nil {
+		return ipv6.String(), nil
+	}
+	return "", fmt.Errorf("interface %q has no usable address", name)
+}
+
+// interfaceAddrs returns the addresses of the named interface. It is a
+// variable so tests can stub the host's interfaces.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q not found: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %q: %w", name, err)
+	}
+	return addrs, nil
+}
+
+// CheckBindAddr verifies that Serf could bind to the address, by opening
+// and closing the TCP and UDP listeners it would use
+func CheckBindAddr(bindAddr string) error {
+	host, port, err := parseBindAddr(bindAddr)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot bind TCP %s: %w", addr, err)
+	}
+	tcp.Close()
+
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot bind UDP %s: %w", addr, err)
+	}
+	udp.Close()
+	return nil
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// stubInterfaces replaces the host's interfaces for the duration of a test
+func stubInterfaces(t *testing.T, ifaces map[string][]string) {
+	t.Helper()
+	orig := interfaceAddrs
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		cidrs, ok := ifaces[name]
+		if !ok {
+			return nil, fmt.Errorf("interface %q not found", name)
+		}
+		var addrs []net.Addr
+		for _, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatalf("ParseCIDR: %v", err)
+			}
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs, nil
+	}
+	t.Cleanup(func() { interfaceAddrs = orig })
+}
+
+func TestParseBindAddr(t *testing.T) {
+	stubInterfaces(t, map[string][]string{
+		"eth0":  {"fe80::1/64", "2001:db8::1/64", "10.0.0.5/24"},
+		"eth1":  {"fe80::2/64", "2001:db8::2/64"},
+		"tun0":  {"fe80::3/64"},
+		"dummy": nil,
+	})
+
+	tests := []struct {
+		bindAddr string
+		host     string
+		port     int
+		wantErr  bool
+	}{
+		{bindAddr: "0.0.0.0:7946", host: "0.0.0.0", port: 7946},
+		{bindAddr: "[::1]:7946", host: "::1", port: 7946},
+		// IPv4 is preferred, whatever the order
+		{bindAddr: "iface:eth0:7946", host: "10.0.0.5", port: 7946},
+		// Link-local IPv6 addresses are skipped
+		{bindAddr: "iface:eth1:7947", host: "2001:db8::2", port: 7947},
+		{bindAddr: "iface:tun0:7946", wantErr: true},
+		{bindAddr: "iface:dummy:7946", wantErr: true},
+		{bindAddr: "iface:wlan0:7946", wantErr: true},
+		{bindAddr: "iface:eth0", wantErr: true},
+		{bindAddr: "iface:eth0:serf", wantErr: true},
+		{bindAddr: "10.0.0.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		host, port, err := parseBindAddr(tt.bindAddr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBindAddr(%q) = %s:%d, want an error", tt.bindAddr, host, port)
+			}
+			continue
+		}
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("parseBindAddr(%q) = %s:%d (%v), want %s:%d", tt.bindAddr, host, port, err, tt.host, tt.port)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// clockReserve is how many Lamport times are reserved in the database
+	// at once, so that only every clockReserve-th broadcast writes to it
+	clockReserve = 1000
+
+	// versionTombstoneTTL is how long the version of a deleted todo is kept
+	// to reject stale events for it. Gossip delivers events within seconds.
+	versionTombstoneTTL = 10 * time.Minute
+)
+
+// eventVersion orders changes to a todo independently of clock skew.
+// The Lamport time decides first; wall-clock time and node ID only break ties.
+type eventVersion struct {
+	Lamport   serf.LamportTime `json:"lamport"`
+	Timestamp int64            `json:"timestamp"`
+	NodeID    string           `json:"node_id"`
+}
+
+// newerThan returns true if v happened after other
+func (v eventVersion) newerThan(other eventVersion) bool {
+	if v.Lamport != other.Lamport {
+		return v.Lamport > other.Lamport
+	}
+	if v.Timestamp != other.Timestamp {
+		return v.Timestamp > other.Timestamp
+	}
+	return v.NodeID > other.NodeID
+}
+
+// versionOf returns the version of a sync event
+func versionOf(event TodoSyncEvent) eventVersion {
+	return eventVersion{
+		Lamport:   event.Lamport,
+		Timestamp: event.Timestamp,
+		NodeID:    event.NodeID,
+	}
+}
+
+// todoKey identifies a todo across namespaces. Namespaces cannot contain
+// a slash, so the key is unambiguous.
+func todoKey(namespace, externID string) string {
+	return namespace + "/" + externID
+}
+
+// recordVersion stores the version of the latest change to a todo.
+// Returns false (and stores nothing) if a newer change was already seen,
+// meaning the event is stale and must not be applied.
+func (c *Cluster) recordVersion(key string, v eventVersion) bool {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	if last, ok := c.versions[key]; ok && !v.newerThan(last) {
+		return false
+	}
+	c.versions[key] = v
+	delete(c.tombstones, key)
+	return true
+}
+
+// knownVersion returns the version of the latest change to a todo, if any
+// was seen since the start or since its deletion was pruned
+func (c *Cluster) knownVersion(key string) (eventVersion, bool) {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	v, ok := c.versions[key]
+	return v, ok
+}
+
+// acceptRepair is like recordVersion, but also accepts the version of the
+// latest change already seen, since a repair re-applies a change this node
+// may have lost. Only repairs older than the latest change are rejected.
+func (c *Cluster) acceptRepair(key string, v eventVersion) bool {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	if last, ok := c.versions[key]; ok {
+		if last.newerThan(v) {
+			return false
+		}
+		if last == v {
+			return true
+		}
+	}
+	c.versions[key] = v
+	delete(c.tombstones, key)
+	return true
+}
+
+// markDeleted schedules the version of a deleted todo for pruning. A later
+// change to the todo (recreating it) cancels the pruning.
+func (c *Cluster) markDeleted(key string) {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	c.tombstones[key] = time.Now()
+}
+
+// pruneVersions forgets the versions of todos deleted more than
+// versionTombstoneTTL ago. Returns the number of pruned versions.
+func (c *Cluster) pruneVersions(now time.Time) int {
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+
+	pruned := 0
+	for key, deletedAt := range c.tombstones {
+		if now.Sub(deletedAt) < versionTombstoneTTL {
+			continue
+		}
+		delete(c.tombstones, key)
+		delete(c.versions, key)
+		pruned++
+	}
+	return pruned
+}
+
+// restoreClock moves the clock past the highest time reserved before the
+// last shutdown. Without it a restarted node would stamp its changes with
+// times its peers already saw, and they would drop them as stale.
+func (c *Cluster) restoreClock() error {
+	saved, err := c.db.LamportTime()
+	if err != nil {
+		return fmt.Errorf("failed to restore lamport clock: %w", err)
+	}
+	c.clockCeiling = serf.LamportTime(saved)
+	c.clock.Witness(c.clockCeiling)
+	return nil
+}
+
+// tick advances the clock for a local change, first reserving a new range
+// of times in the database whenever the clock reaches the reserved ceiling
+func (c *Cluster) tick() serf.LamportTime {
+	t := c.clock.Increment()
+
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+
+	if t >= c.clockCeiling {
+		ceiling := t + clockReserve
+		if err := c.db.SaveLamportTime(uint64(ceiling)); err != nil {
+			// Retried on the next tick; only a restart before that loses t
+			log.Printf("❌ Failed to reserve lamport times: %v", err)
+			return t
+		}
+		c.clockCeiling = ceiling
+	}
+	return t
+}
@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/hashicorp/serf/serf"
+)
+
+func newTestCluster(t *testing.T, db *database.DB) *Cluster {
+	t.Helper()
+	c := &Cluster{
+		db:          db,
+		nodeID:      "node-a",
+		shutdown:    make(chan struct{}),
+		readyCh:     make(chan struct{}),
+		versions:    make(map[string]eventVersion),
+		tombstones:  make(map[string]time.Time),
+		outboxKeys:  make(map[string]bool),
+		subscribers: make(map[chan ActivityEvent]struct{}),
+		opts: Options{
+			UserEventSizeLimit:     512,
+			QueryResponseSizeLimit: 1024,
+			BroadcastRetryMaxAge:   5 * time.Minute,
+		},
+	}
+	if db != nil {
+		if err := c.restoreClock(); err != nil {
+			t.Fatalf("restoreClock: %v", err)
+		}
+		if err := c.restoreOutbox(); err != nil {
+			t.Fatalf("restoreOutbox: %v", err)
+		}
+	}
+	return c
+}
+
+func openTestDB(t *testing.T, path string) *database.DB {
+	t.Helper()
+	db, err := database.New(path, database.Options{})
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	return db
+}
+
+func TestClockResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+	key := todoKey("default", "todo-1")
+	peer := newTestCluster(t, nil)
+
+	db := openTestDB(t, path)
+	node := newTestCluster(t, db)
+	for i := 0; i < 3; i++ {
+		node.tick()
+	}
+	// Times witnessed from peers are covered as well
+	node.clock.Witness(5000)
+	before := node.tick()
+	if !peer.recordVersion(key, eventVersion{Lamport: before, Timestamp: 100, NodeID: "node-a"}) {
+		t.Fatal("peer rejected the first change")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	db = openTestDB(t, path)
+	defer db.Close()
+	restarted := newTestCluster(t, db)
+	after := restarted.tick()
+
+	if after <= before {
+		t.Fatalf("lamport time after restart = %d, want > %d", after, before)
+	}
+	if !peer.recordVersion(key, eventVersion{Lamport: after, Timestamp: 100, NodeID: "node-a"}) {
+		t.Fatal("peer dropped the change made after the restart as stale")
+	}
+}
+
+func TestTickReservesBeforeCeiling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+	db := openTestDB(t, path)
+	defer db.Close()
+	c := newTestCluster(t, db)
+
+	for i := 0; i < 2*clockReserve+5; i++ {
+		ti := c.tick()
+		saved, err := db.LamportTime()
+		if err != nil {
+			t.Fatalf("LamportTime: %v", err)
+		}
+		if serf.LamportTime(saved) < ti {
+			t.Fatalf("tick %d returned time %d above the reserved %d", i, ti, saved)
+		}
+	}
+}
+
+func TestRecordVersionOrdering(t *testing.T) {
+	c := newTestCluster(t, nil)
+	key := todoKey("default", "todo-1")
+
+	if !c.recordVersion(key, eventVersion{Lamport: 5, Timestamp: 100, NodeID: "node-b"}) {
+		t.Fatal("first version rejected")
+	}
+
+	tests := []struct {
+		name string
+		v    eventVersion
+		want bool
+	}{
+		{"same version", eventVersion{Lamport: 5, Timestamp: 100, NodeID: "node-b"}, false},
+		{"lower lamport, later wall clock", eventVersion{Lamport: 4, Timestamp: 999, NodeID: "node-c"}, false},
+		{"same lamport, lower node id", eventVersion{Lamport: 5, Timestamp: 100, NodeID: "node-a"}, false},
+		{"same lamport, later wall clock", eventVersion{Lamport: 5, Timestamp: 101, NodeID: "node-a"}, true},
+		{"higher lamport, earlier wall clock", eventVersion{Lamport: 6, Timestamp: 1, NodeID: "node-a"}, true},
+	}
+	for _, tt := range tests {
+		if got := c.recordVersion(key, tt.v); got != tt.want {
+			t.Errorf("%s: recordVersion = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptRepair(t *testing.T) {
+	c := newTestCluster(t, nil)
+	key := todoKey("default", "todo-1")
+	latest := eventVersion{Lamport: 5, Timestamp: 100, NodeID: "node-b"}
+
+	if !c.acceptRepair(todoKey("default", "unknown"), eventVersion{}) {
+		t.Error("repair of a todo without a known version rejected")
+	}
+
+	c.recordVersion(key, latest)
+	if !c.acceptRepair(key, latest) {
+		t.Error("repair carrying the latest version rejected")
+	}
+	if c.acceptRepair(key, eventVersion{Lamport: 4, Timestamp: 200, NodeID: "node-c"}) {
+		t.Error("repair older than the latest change accepted")
+	}
+	newer := eventVersion{Lamport: 6, Timestamp: 100, NodeID: "node-c"}
+	if !c.acceptRepair(key, newer) {
+		t.Error("repair newer than the latest change rejected")
+	}
+	if c.versions[key] != newer {
+		t.Errorf("version after repair = %+v, want %+v", c.versions[key], newer)
+	}
+}
+
+func TestPruneVersions(t *testing.T) {
+	c := newTestCluster(t, nil)
+	deleted := todoKey("default", "deleted")
+	recreated := todoKey("default", "recreated")
+	live := todoKey("default", "live")
+
+	c.recordVersion(deleted, eventVersion{Lamport: 1})
+	c.markDeleted(deleted)
+	c.recordVersion(recreated, eventVersion{Lamport: 2})
+	c.markDeleted(recreated)
+	c.recordVersion(recreated, eventVersion{Lamport: 3})
+	c.recordVersion(live, eventVersion{Lamport: 4})
+
+	if n := c.pruneVersions(time.Now()); n != 0 {
+		t.Errorf("pruned %d versions before the tombstone TTL, want 0", n)
+	}
+	if n := c.pruneVersions(time.Now().Add(versionTombstoneTTL)); n != 1 {
+		t.Errorf("pruned %d versions after the tombstone TTL, want 1", n)
+	}
+	if _, ok := c.versions[deleted]; ok {
+		t.Error("version of the deleted todo was kept")
+	}
+	if _, ok := c.versions[recreated]; !ok {
+		t.Error("version of the recreated todo was pruned")
+	}
+	if _, ok := c.versions[live]; !ok {
+		t.Error("version of the live todo was pruned")
+	}
+}
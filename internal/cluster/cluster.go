@@ -1,10 +1,12 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net"
-	"strconv"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
@@ -19,22 +21,141 @@ type Cluster struct {
 	nodeID   string
 	eventCh  chan serf.Event
 	shutdown chan struct{}
-	ready    bool
-	readyCh  chan struct{}
-	stopped  bool
+	opts     Options
+
+	// Lifecycle flags, read by HTTP handlers and background loops
+	ready     atomic.Bool
+	readyCh   chan struct{}
+	readyOnce sync.Once
+	stopped   atomic.Bool
+	left      atomic.Bool
+
+	// Background goroutines using the database, which Stop waits for
+	loops sync.WaitGroup
+
+	ownedShards map[int]bool
+
+	// Set while a full sync is running so overlapping requests are ignored
+	syncing atomic.Bool
+
+	// Slots for answering full state queries of other nodes (see
+	// acquireSyncSlot), so many newcomers at once can't overload this node
+	syncSlots chan struct{}
+
+	// Unix nanoseconds of becoming ready and of the last applied sync event
+	// or full sync, to detect nodes that stopped receiving gossip
+	readyAt    atomic.Int64
+	lastSyncAt atomic.Int64
+
+	// Logical clock and latest seen change per extern_id for ordering events.
+	// The clock never runs past clockCeiling, the time reserved in the
+	// database, so it resumes above every time used before a restart.
+	clock        serf.LamportClock
+	clockMu      sync.Mutex
+	clockCeiling serf.LamportTime
+	versionsMu   sync.Mutex
+	versions     map[string]eventVersion
+	tombstones   map[string]time.Time
+
+	// Todos fetched from peers on a local miss (see FetchTodo)
+	fetchedMu sync.Mutex
+	fetched   map[string]fetchedTodo
+
+	// Live activity observers (see Subscribe)
+	subscribersMu sync.Mutex
+	subscribers   map[chan ActivityEvent]struct{}
+
+	// Todos with a failed broadcast in the database outbox (see outbox.go)
+	outboxMu   sync.Mutex
+	outboxKeys map[string]bool
+
+	// Sends a user event; Serf's UserEvent, replaceable in tests
+	userEvent func(name string, payload []byte, coalesce bool) error
+}
+
+// Options contains optional cluster settings
+type Options struct {
+	LeaveTimeout time.Duration // Maximum time to wait for a graceful leave (default 5s)
+
+	// Keep retrying the seeds for this long after Start before becoming
+	// the first or a standalone node, so nodes started simultaneously find
+	// each other; zero gives up after the initial retries
+	JoinGracePeriod time.Duration
+	TotalShards     int    // Number of extern_id hash shards (0 disables sharding)
+	OwnedShards     []int  // Shards stored by this node when sharding is enabled
+	EncryptKey      []byte // Serf gossip encryption key (nil disables encryption)
+	FetchOnMiss     bool   // Look up todos missing locally on other nodes
+
+	// Drop update events for todos completed locally, matching the API
+	// rejecting changes to completed todos
+	ImmutableAfterCompletion bool
+
+	// Serf payload size limits in bytes; zero keeps Serf's default. They
+	// must be the same on all nodes. Full sync pages are sized to fit
+	// QueryResponseSizeLimit.
+	QuerySizeLimit         int
+	QueryResponseSizeLimit int
+	UserEventSizeLimit     int
+
+	// Sync event payload encoding, EncodingJSON (default) or
+	// EncodingMsgpack. Nodes decode both, but older releases only JSON.
+	EventEncoding string
+
+	// Serf protocol version to speak (0 keeps Serf's default). Pinning an
+	// older version lets nodes of different releases talk during upgrades.
+	ProtocolVersion int
+
+	// Failed broadcasts are retried for this long (default 5m)
+	BroadcastRetryMaxAge time.Duration
+
+	// Send update events as coalescable, and coalesce those received
+	// within this period: only the latest update to a todo is applied,
+	// superseded ones are dropped, and updates arrive up to this much
+	// later. Zero sends and applies every update. All nodes must run a
+	// release that understands coalesced updates.
+	UpdateCoalescePeriod time.Duration
+
+	// File the gossip encryption keyring is persisted to when keys are
+	// rotated. If it exists, its keys replace EncryptKey, which is then
+	// outdated. Empty keeps the keyring in memory only.
+	KeyringFile string
+
+	// Readiness fails if no sync event was applied for this long while other
+	// members are alive; zero disables the check
+	MaxSyncSilence time.Duration
+
+	// Todo expiry; a zero TTL disables that rule. Per-todo expires_at
+	// is always honored.
+	TTLAfterCreation   time.Duration // Delete todos this long after creation
+	TTLAfterCompletion time.Duration // Delete completed todos this long after completion
+	SweepInterval      time.Duration // How often to delete expired todos (default 60s)
+
+	// Full state queries answered at the same time (default 4); further
+	// queries wait for a free slot until they time out
+	MaxConcurrentSyncs int
+
+	// Background consistency repair; a zero interval disables it
+	RepairInterval   time.Duration // How often to check a sample of todos on the other nodes
+	RepairSampleSize int           // Todos checked per round (default 10)
 }
 
 // New creates a new Cluster instance
-func New(nodeID string, bindAddr string, db *database.DB) (*Cluster, error) {
-	// Parse bind address (format: "IP:Port")
-	host, portStr, err := net.SplitHostPort(bindAddr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid bind address %q: %w", bindAddr, err)
+func New(nodeID string, bindAddr string, db *database.DB, opts Options) (*Cluster, error) {
+	if err := validateNodeName(nodeID); err != nil {
+		return nil, err
 	}
 
-	port, err := strconv.Atoi(portStr)
+	// Parse bind address (format: "IP:Port" or "iface:<name>:<port>")
+	host, port, err := parseBindAddr(bindAddr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid port in bind address %q: %w", bindAddr, err)
+		return nil, err
+	}
+
+	if err := validateProtocolVersion(opts.ProtocolVersion); err != nil {
+		return nil, err
+	}
+	if err := validateEncoding(opts.EventEncoding); err != nil {
+		return nil, err
 	}
 
 	// Create Serf configuration
@@ -42,6 +163,64 @@ func New(nodeID string, bindAddr string, db *database.DB) (*Cluster, error) {
 	config.NodeName = nodeID
 	config.MemberlistConfig.BindAddr = host
 	config.MemberlistConfig.BindPort = port
+	if len(opts.EncryptKey) > 0 {
+		config.MemberlistConfig.SecretKey = opts.EncryptKey
+		if opts.KeyringFile != "" {
+			keyring, err := loadKeyring(opts.KeyringFile)
+			if err != nil {
+				return nil, err
+			}
+			if keyring != nil {
+				// Keys rotated before the restart replace the configured key
+				config.MemberlistConfig.SecretKey = nil
+				config.MemberlistConfig.Keyring = keyring
+				log.Printf("🔑 Loaded gossip encryption keyring from %s", opts.KeyringFile)
+			}
+			config.KeyringFile = opts.KeyringFile
+		}
+	}
+	if opts.QuerySizeLimit > 0 {
+		config.QuerySizeLimit = opts.QuerySizeLimit
+	}
+	if opts.QueryResponseSizeLimit > 0 {
+		config.QueryResponseSizeLimit = opts.QueryResponseSizeLimit
+	}
+	if opts.UserEventSizeLimit > 0 {
+		config.UserEventSizeLimit = opts.UserEventSizeLimit
+	}
+	opts.QueryResponseSizeLimit = config.QueryResponseSizeLimit
+	opts.UserEventSizeLimit = config.UserEventSizeLimit
+	if opts.UpdateCoalescePeriod > 0 {
+		config.UserCoalescePeriod = opts.UpdateCoalescePeriod
+		config.UserQuiescentPeriod = opts.UpdateCoalescePeriod
+	}
+	if opts.ProtocolVersion > 0 {
+		config.ProtocolVersion = uint8(opts.ProtocolVersion)
+	}
+
+	if opts.LeaveTimeout <= 0 {
+		opts.LeaveTimeout = 5 * time.Second
+	}
+	if opts.SweepInterval <= 0 {
+		opts.SweepInterval = 60 * time.Second
+	}
+	if opts.MaxConcurrentSyncs <= 0 {
+		opts.MaxConcurrentSyncs = 4
+	}
+	if opts.RepairSampleSize <= 0 {
+		opts.RepairSampleSize = 10
+	}
+	if opts.BroadcastRetryMaxAge <= 0 {
+		opts.BroadcastRetryMaxAge = 5 * time.Minute
+	}
+
+	if err := validateShards(opts.TotalShards, opts.OwnedShards); err != nil {
+		return nil, fmt.Errorf("invalid sharding config: %w", err)
+	}
+	ownedShards := make(map[int]bool, len(opts.OwnedShards))
+	for _, shard := range opts.OwnedShards {
+		ownedShards[shard] = true
+	}
 
 	// Create event channel
 	eventCh := make(chan serf.Event, 256)
@@ -53,9 +232,24 @@ func New(nodeID string, bindAddr string, db *database.DB) (*Cluster, error) {
 		nodeID:   nodeID,
 		eventCh:  eventCh,
 		shutdown: make(chan struct{}),
-		ready:    false,
 		readyCh:  make(chan struct{}),
-		stopped:  false,
+		opts:     opts,
+
+		syncSlots: make(chan struct{}, opts.MaxConcurrentSyncs),
+
+		ownedShards: ownedShards,
+		versions:    make(map[string]eventVersion),
+		tombstones:  make(map[string]time.Time),
+		fetched:     make(map[string]fetchedTodo),
+		subscribers: make(map[chan ActivityEvent]struct{}),
+		outboxKeys:  make(map[string]bool),
+	}
+
+	if err := cluster.restoreClock(); err != nil {
+		return nil, err
+	}
+	if err := cluster.restoreOutbox(); err != nil {
+		return nil, err
 	}
 
 	// Create Serf instance
@@ -65,14 +259,56 @@ func New(nodeID string, bindAddr string, db *database.DB) (*Cluster, error) {
 	}
 
 	cluster.serf = serfInstance
+	cluster.userEvent = serfInstance.UserEvent
 
 	return cluster, nil
 }
 
-// Start starts the cluster and joins the seed nodes
+// nodeNameRe matches node names Serf handles reliably
+var nodeNameRe = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+// validateNodeName rejects node names Serf would mishandle, using Serf's
+// own rules: alphanumerics, dashes and dots, at most 128 characters
+func validateNodeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid node name: must not be empty")
+	}
+	if len(name) > serf.MaxNodeNameLength {
+		return fmt.Errorf("invalid node name %q: %d characters, at most %d allowed", name, len(name), serf.MaxNodeNameLength)
+	}
+	if !nodeNameRe.MatchString(name) {
+		return fmt.Errorf("invalid node name %q: only letters, digits, '-' and '.' are allowed", name)
+	}
+	return nil
+}
+
+// validateProtocolVersion rejects protocol versions outside the range this
+// Serf release speaks; 0 selects Serf's default
+func validateProtocolVersion(version int) error {
+	if version == 0 {
+		return nil
+	}
+	if version < int(serf.ProtocolVersionMin) || version > int(serf.ProtocolVersionMax) {
+		return fmt.Errorf("invalid protocol_version %d: Serf supports versions %d to %d", version, serf.ProtocolVersionMin, serf.ProtocolVersionMax)
+	}
+	return nil
+}
+
+// Start starts the cluster and joins the seed nodes. After joining, it
+// blocks until the initial full sync completes or joinTimeout passes.
 func (c *Cluster) Start(seeds []string, joinTimeout time.Duration) error {
-	// Start event handler
-	go c.handleEvents()
+	if joinTimeout <= 0 {
+		return fmt.Errorf("join timeout must be positive, got %v", joinTimeout)
+	}
+	startedAt := time.Now()
+
+	// Start event handler, expiry sweeper and broadcast retries
+	c.goLoop(c.handleEvents)
+	c.goLoop(c.sweepExpired)
+	c.goLoop(c.retryBroadcasts)
+	if c.opts.RepairInterval > 0 {
+		c.goLoop(c.repairLoop)
+	}
 
 	// Join cluster via seeds
 	if len(seeds) > 0 {
@@ -85,7 +321,7 @@ func (c *Cluster) Start(seeds []string, joinTimeout time.Duration) error {
 
 		for i := 0; i < maxRetries; i++ {
 			if i > 0 {
-				backoff := time.Duration(i) * 2 * time.Second
+				backoff := time.Duration(i) * joinRetryBackoff
 				log.Printf("⏳ Retry %d/%d in %v...", i+1, maxRetries, backoff)
 				time.Sleep(backoff)
 			}
@@ -104,6 +340,11 @@ func (c *Cluster) Start(seeds []string, joinTimeout time.Duration) error {
 			}
 		}
 
+		// Seeds may just be slow to come up
+		if !joined && c.opts.JoinGracePeriod > 0 {
+			joined, lastErr = c.joinUntil(seeds, startedAt.Add(c.opts.JoinGracePeriod), lastErr)
+		}
+
 		// If we couldn't join but didn't error, we might be the first node
 		if !joined && lastErr == nil {
 			log.Println("ℹ️  No seeds responded, starting as first node")
@@ -118,15 +359,19 @@ func (c *Cluster) Start(seeds []string, joinTimeout time.Duration) error {
 			return nil
 		}
 
+		// Request full sync now that the member list is known, so a
+		// single responder can be elected
+		log.Println("ℹ️  Joined cluster, requesting full sync...")
+		c.goLoop(c.requestFullSync)
+
 		// Wait for full sync to complete (with timeout)
 		log.Println("⏳ Waiting for full sync to complete...")
-		syncTimeout := 30 * time.Second
 		select {
 		case <-c.readyCh:
 			log.Println("✅ Node is ready")
 			return nil
-		case <-time.After(syncTimeout):
-			log.Printf("⚠️  Full sync timeout after %v, continuing anyway", syncTimeout)
+		case <-time.After(joinTimeout):
+			log.Printf("⚠️  Full sync timeout after %v, continuing anyway", joinTimeout)
 			c.markReady()
 			return nil
 		}
@@ -138,33 +383,138 @@ func (c *Cluster) Start(seeds []string, joinTimeout time.Duration) error {
 	return nil
 }
 
-// Stop gracefully shuts down the cluster
-func (c *Cluster) Stop() error {
+// Join retry timing, variables so tests can shorten them
+var (
+	// joinRetryBackoff grows linearly between the initial join attempts
+	joinRetryBackoff = 2 * time.Second
+
+	// graceRetryInterval is how often seeds are retried during the join
+	// grace period
+	graceRetryInterval = time.Second
+)
+
+// joinUntil retries joining the seeds until one responds or the deadline
+// passes. It returns the error of the last attempt, or lastErr if the
+// deadline had already passed.
+func (c *Cluster) joinUntil(seeds []string, deadline time.Time, lastErr error) (bool, error) {
+	if time.Now().Before(deadline) {
+		log.Printf("⏳ Waiting up to %v for seeds to come up...", time.Until(deadline).Round(time.Second))
+	}
+	for time.Now().Before(deadline) {
+		time.Sleep(min(graceRetryInterval, time.Until(deadline)))
+
+		numJoined, err := c.serf.Join(seeds, true)
+		lastErr = err
+		if err == nil && numJoined > 0 {
+			log.Printf("✅ Successfully joined %d nodes", numJoined)
+			return true, nil
+		}
+	}
+	return false, lastErr
+}
+
+// goLoop runs f in a goroutine that Stop waits for
+func (c *Cluster) goLoop(f func()) {
+	c.loops.Add(1)
+	go func() {
+		defer c.loops.Done()
+		f()
+	}()
+}
+
+// Stop gracefully shuts down the cluster. It returns once the event handler
+// has drained the buffered events and the background loops have finished,
+// so the database can be closed afterwards, or when ctx is done.
+func (c *Cluster) Stop(ctx context.Context) error {
 	// Check if already stopped (idempotent)
-	if c.stopped {
+	if !c.stopped.CompareAndSwap(false, true) {
 		return nil
 	}
-	c.stopped = true
 
 	log.Println("🛑 Shutting down cluster...")
 
-	// Signal shutdown to event handler
-	close(c.shutdown)
-
-	// Leave the cluster gracefully
-	if err := c.serf.Leave(); err != nil {
-		log.Printf("⚠️  Error leaving cluster: %v", err)
+	// Leave the cluster gracefully (unless already left via Leave)
+	if c.left.CompareAndSwap(false, true) {
+		if err := c.leaveWithTimeout(); err != nil {
+			log.Printf("⚠️  Error leaving cluster: %v", err)
+		} else {
+			log.Println("👋 Left cluster gracefully")
+		}
 	}
 
 	// Shutdown Serf
-	if err := c.serf.Shutdown(); err != nil {
-		return fmt.Errorf("failed to shutdown serf: %w", err)
+	serfErr := c.serf.Shutdown()
+
+	// Signal shutdown to the background loops only now, so the event
+	// handler keeps consuming while Serf emits its last events and then
+	// drains what is still buffered
+	close(c.shutdown)
+	if err := c.waitLoops(ctx); err != nil {
+		return err
+	}
+	if serfErr != nil {
+		return fmt.Errorf("failed to shutdown serf: %w", serfErr)
 	}
 
 	log.Println("✅ Cluster shutdown complete")
 	return nil
 }
 
+// waitLoops waits for the goroutines started by goLoop to finish
+func (c *Cluster) waitLoops(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.loops.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background loops did not finish: %w", ctx.Err())
+	}
+}
+
+// Leave gracefully leaves the cluster while keeping the process running.
+// After leaving, the node reports not ready and stops broadcasting events.
+func (c *Cluster) Leave() error {
+	// Check if already left (idempotent)
+	if !c.left.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	log.Println("👋 Leaving cluster...")
+
+	if err := c.leaveWithTimeout(); err != nil {
+		return fmt.Errorf("failed to leave cluster: %w", err)
+	}
+
+	log.Println("✅ Left cluster")
+	return nil
+}
+
+// leaveWithTimeout leaves the Serf cluster, giving up after the configured
+// leave timeout so a partitioned network can't block shutdown forever
+func (c *Cluster) leaveWithTimeout() error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.serf.Leave()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(c.opts.LeaveTimeout):
+		return fmt.Errorf("leave did not complete within %v", c.opts.LeaveTimeout)
+	}
+}
+
+// HasLeft returns true if the node has left the cluster
+func (c *Cluster) HasLeft() bool {
+	return c.left.Load()
+}
+
 // Members returns the current cluster members
 func (c *Cluster) Members() []serf.Member {
 	return c.serf.Members()
@@ -177,15 +527,36 @@ func (c *Cluster) LocalNode() string {
 
 // markReady marks the cluster as ready and signals waiting goroutines
 func (c *Cluster) markReady() {
-	if !c.ready {
-		c.ready = true
+	c.readyOnce.Do(func() {
+		c.readyAt.Store(time.Now().UnixNano())
+		c.ready.Store(true)
 		close(c.readyCh)
-	}
+	})
 }
 
 // IsReady returns true if the cluster is ready to serve requests
 func (c *Cluster) IsReady() bool {
-	return c.ready
+	return c.ready.Load() && !c.left.Load()
+}
+
+// LastSyncAt returns when the last sync event from a peer was applied or a
+// full sync completed, or the zero time if neither happened yet
+func (c *Cluster) LastSyncAt() time.Time {
+	if ns := c.lastSyncAt.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// SyncSilent returns true if MaxSyncSilence is set, other members are alive
+// and nothing was synced from them for longer than MaxSyncSilence since the
+// node became ready, which suggests it is partitioned
+func (c *Cluster) SyncSilent() bool {
+	if c.opts.MaxSyncSilence <= 0 || !c.IsReady() || len(c.alivePeers()) == 0 {
+		return false
+	}
+	since := max(c.lastSyncAt.Load(), c.readyAt.Load())
+	return time.Since(time.Unix(0, since)) > c.opts.MaxSyncSilence
 }
 
 // GetMemberInfo returns information about all cluster members
@@ -198,13 +569,41 @@ func (c *Cluster) GetMemberInfo() []models.ClusterMemberInfo {
 			Name:   member.Name,
 			Addr:   member.Addr.String(),
 			Status: member.Status.String(),
+			RTTMs:  c.estimateRTT(member.Name),
 		}
 	}
 
 	return info
 }
 
+// estimateRTT returns the estimated round-trip time in milliseconds from the
+// local node to the given member using Serf's network coordinates.
+// Returns nil if coordinates are not (yet) available.
+func (c *Cluster) estimateRTT(name string) *float64 {
+	local, err := c.serf.GetCoordinate()
+	if err != nil {
+		return nil
+	}
+
+	var rtt float64
+	if name != c.nodeID {
+		other, ok := c.serf.GetCachedCoordinate(name)
+		if !ok || other == nil || !local.IsCompatibleWith(other) {
+			return nil
+		}
+		rtt = float64(local.DistanceTo(other)) / float64(time.Millisecond)
+	}
+
+	return &rtt
+}
+
 // MemberCount returns the number of cluster members
 func (c *Cluster) MemberCount() int {
 	return len(c.serf.Members())
 }
+
+// AliveMemberCount returns the number of alive cluster members, including
+// this node
+func (c *Cluster) AliveMemberCount() int {
+	return len(c.alivePeers()) + 1
+}
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/hashicorp/serf/serf"
+)
+
+// startTestNode starts a node on a random local port, joining the given
+// seeds, and stops it when the test ends
+func startTestNode(t *testing.T, name string, seeds ...string) (*Cluster, *database.DB) {
+	t.Helper()
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	c, err := New(name, "127.0.0.1:0", db, Options{LeaveTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := c.Start(seeds, 5*time.Second); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.Stop(ctx)
+		db.Close()
+	})
+	return c, db
+}
+
+// addrOf returns the address other nodes join a test node at
+func addrOf(c *Cluster) string {
+	member := c.serf.LocalMember()
+	return fmt.Sprintf("%s:%d", member.Addr, member.Port)
+}
+
+func TestStopAppliesBufferedEvents(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+
+	c, err := New("node-a", "127.0.0.1:0", db, Options{LeaveTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := c.Start(nil, time.Second); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// Buffer events from another node faster than they are applied
+	const total = 100
+	for i := 0; i < total; i++ {
+		payload, err := json.Marshal(TodoSyncEvent{
+			Type:      "created",
+			Namespace: "default",
+			ExternID:  fmt.Sprintf("todo-%d", i),
+			Todo:      "Buy milk",
+			NodeID:    "node-b",
+			Timestamp: time.Now().Unix(),
+			Lamport:   serf.LamportTime(i + 1),
+		})
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		c.eventCh <- serf.UserEvent{Name: EventTodoCreated, Payload: payload}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	// Stop returned, so nothing writes to the database anymore
+	count, err := db.CountTodos()
+	if err != nil {
+		t.Fatalf("CountTodos: %v", err)
+	}
+	if count != total {
+		t.Errorf("%d todos applied before Stop returned, want %d", count, total)
+	}
+}
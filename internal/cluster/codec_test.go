@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestSyncEventRoundTrip(t *testing.T) {
+	completed := true
+	expiresAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2024, 2, 1, 8, 30, 15, 0, time.UTC)
+	event := TodoSyncEvent{
+		Type:      "repair",
+		Namespace: "team-a",
+		ExternID:  "todo-1",
+		Todo:      "Buy milk",
+		Completed: &completed,
+		ExpiresAt: &expiresAt,
+		Origin:    "node-b",
+		Metadata:  models.Metadata{"priority": "high", "list": "shopping"},
+		NodeID:    "node-a",
+		Timestamp: 1700000000,
+		Lamport:   42,
+		UpdatedAt: &updatedAt,
+	}
+
+	sizes := make(map[string]int)
+	for _, encoding := range []string{EncodingJSON, EncodingMsgpack} {
+		t.Run(encoding, func(t *testing.T) {
+			c := newTestCluster(t, nil)
+			c.opts.EventEncoding = encoding
+
+			payload, err := c.encodeSyncEvent(event)
+			if err != nil {
+				t.Fatalf("encodeSyncEvent: %v", err)
+			}
+			sizes[encoding] = len(payload)
+			if isMsgpack := payload[0] == msgpackPrefix; isMsgpack != (encoding == EncodingMsgpack) {
+				t.Errorf("payload prefix %#x does not match encoding %s", payload[0], encoding)
+			}
+
+			got, err := decodeSyncEvent(payload)
+			if err != nil {
+				t.Fatalf("decodeSyncEvent: %v", err)
+			}
+
+			// Times may come back in another location, so compare them apart
+			if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("expires_at = %v, want %v", got.ExpiresAt, expiresAt)
+			}
+			if got.UpdatedAt == nil || !got.UpdatedAt.Equal(updatedAt) {
+				t.Errorf("updated_at = %v, want %v", got.UpdatedAt, updatedAt)
+			}
+			if got.Completed == nil || *got.Completed != completed {
+				t.Errorf("completed = %v, want %v", got.Completed, completed)
+			}
+
+			got.ExpiresAt, got.UpdatedAt, got.Completed = nil, nil, nil
+			want := event
+			want.ExpiresAt, want.UpdatedAt, want.Completed = nil, nil, nil
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded event = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if sizes[EncodingMsgpack] >= sizes[EncodingJSON] {
+		t.Errorf("msgpack payload takes %d bytes, JSON only %d", sizes[EncodingMsgpack], sizes[EncodingJSON])
+	}
+}
+
+func TestDecodeSyncEventDefaults(t *testing.T) {
+	for _, encoding := range []string{EncodingJSON, EncodingMsgpack} {
+		c := newTestCluster(t, nil)
+		c.opts.EventEncoding = encoding
+
+		// As sent by a node without namespace or origin support
+		payload, err := c.encodeSyncEvent(TodoSyncEvent{Type: "created", ExternID: "todo-1", Todo: "Buy milk", NodeID: "node-b"})
+		if err != nil {
+			t.Fatalf("%s: encodeSyncEvent: %v", encoding, err)
+		}
+		got, err := decodeSyncEvent(payload)
+		if err != nil {
+			t.Fatalf("%s: decodeSyncEvent: %v", encoding, err)
+		}
+		if got.Namespace != models.DefaultNamespace || got.Origin != "node-b" {
+			t.Errorf("%s: namespace %q, origin %q; want %q, node-b", encoding, got.Namespace, got.Origin, models.DefaultNamespace)
+		}
+	}
+}
@@ -1,34 +1,74 @@
 package cluster
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
+	"maps"
+	"time"
+	"unicode/utf8"
 
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/metrics"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
 	"github.com/hashicorp/serf/serf"
 )
 
+// queueSampleInterval is how often the event channel length is published
+const queueSampleInterval = 5 * time.Second
+
 // handleEvents processes Serf events from the event channel
 func (c *Cluster) handleEvents() {
+	ticker := time.NewTicker(queueSampleInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case event := <-c.eventCh:
-			switch e := event.(type) {
-			case serf.MemberEvent:
-				c.handleMemberEvent(e)
-			case serf.UserEvent:
-				c.handleUserEvent(e)
-			case *serf.Query:
-				c.handleQuery(e)
-			default:
-				log.Printf("Unknown event type: %T", e)
-			}
+			c.processEvent(event)
+		case <-ticker.C:
+			metrics.EventQueueLength.Set(float64(len(c.eventCh)))
 		case <-c.shutdown:
+			c.drainEvents()
 			log.Println("Event handler shutting down")
 			return
 		}
 	}
 }
 
+// drainEvents processes the events still buffered in the event channel.
+// Stop only signals shutdown after Serf has shut down, so nothing is
+// written to the channel anymore and buffered events aren't lost.
+func (c *Cluster) drainEvents() {
+	for {
+		select {
+		case event := <-c.eventCh:
+			c.processEvent(event)
+		default:
+			return
+		}
+	}
+}
+
+// processEvent dispatches a single Serf event to its handler
+func (c *Cluster) processEvent(event serf.Event) {
+	start := time.Now()
+	var eventType string
+	switch e := event.(type) {
+	case serf.MemberEvent:
+		eventType = e.Type.String()
+		c.handleMemberEvent(e)
+	case serf.UserEvent:
+		eventType = eventKind(e.Name)
+		c.handleUserEvent(e)
+	case *serf.Query:
+		eventType = e.Name
+		c.handleQuery(e)
+	default:
+		eventType = "unknown"
+		log.Printf("Unknown event type: %T", e)
+	}
+	metrics.EventProcessingSeconds.WithLabelValues(eventType).Observe(time.Since(start).Seconds())
+}
+
 // handleMemberEvent handles cluster membership events
 func (c *Cluster) handleMemberEvent(event serf.MemberEvent) {
 	for _, member := range event.Members {
@@ -36,12 +76,6 @@ func (c *Cluster) handleMemberEvent(event serf.MemberEvent) {
 		case serf.EventMemberJoin:
 			log.Printf("🎉 Node joined: %s (%s)", member.Name, member.Addr)
 
-			// If I'm the new node, request full sync
-			if member.Name == c.nodeID {
-				log.Println("ℹ️  I'm the new node, requesting full sync...")
-				go c.requestFullSync()
-			}
-
 		case serf.EventMemberLeave:
 			log.Printf("👋 Node left gracefully: %s", member.Name)
 
@@ -54,17 +88,48 @@ func (c *Cluster) handleMemberEvent(event serf.MemberEvent) {
 		case serf.EventMemberReap:
 			log.Printf("🗑️  Node reaped: %s", member.Name)
 		}
+
+		eventType := memberEventType(event.Type)
+		metrics.MemberEvents.WithLabelValues(eventType).Inc()
+		c.publish(ActivityEvent{
+			Category: ActivityMember,
+			Type:     eventType,
+			Node:     member.Name,
+			Addr:     member.Addr.String(),
+		})
+
+		// Persist the membership change for post-incident analysis
+		if err := c.db.RecordClusterEvent(member.Name, eventType, member.Addr.String()); err != nil {
+			log.Printf("❌ Failed to record cluster event: %v", err)
+		}
 	}
 }
 
-// handleUserEvent handles custom user events (todo sync)
-func (c *Cluster) handleUserEvent(event serf.UserEvent) {
-	// Skip events from myself
-	if event.Name == c.nodeID {
-		return
+// memberEventType returns the audit log name of a membership event type
+func memberEventType(t serf.EventType) string {
+	switch t {
+	case serf.EventMemberJoin:
+		return "join"
+	case serf.EventMemberLeave:
+		return "leave"
+	case serf.EventMemberFailed:
+		return "failed"
+	case serf.EventMemberUpdate:
+		return "update"
+	case serf.EventMemberReap:
+		return "reap"
+	default:
+		return t.String()
 	}
+}
 
-	switch event.Name {
+// handleUserEvent handles custom user events (todo sync)
+func (c *Cluster) handleUserEvent(event serf.UserEvent) {
+	// Events carry their sender in the payload, so each handler skips its
+	// own events after decoding (see isOwnEvent)
+	kind := eventKind(event.Name)
+	metrics.SyncBytesReceived.WithLabelValues(kind).Add(float64(len(event.Payload)))
+	switch kind {
 	case EventTodoCreated:
 		c.handleTodoCreated(event.Payload)
 	case EventTodoUpdated:
@@ -76,72 +141,158 @@ func (c *Cluster) handleUserEvent(event serf.UserEvent) {
 	}
 }
 
+// syncApplied records that a todo change from a peer was applied and
+// publishes it to activity subscribers
+func (c *Cluster) syncApplied(event TodoSyncEvent) {
+	c.lastSyncAt.Store(time.Now().UnixNano())
+	c.publish(ActivityEvent{
+		Category:  ActivitySync,
+		Type:      event.Type,
+		Node:      event.NodeID,
+		Namespace: event.Namespace,
+		ExternID:  event.ExternID,
+	})
+}
+
+// isOwnEvent returns true if this node broadcast the event. Serf delivers
+// user events to their sender too, and the event name never identifies it.
+func (c *Cluster) isOwnEvent(event TodoSyncEvent) bool {
+	return event.NodeID == c.nodeID
+}
+
 // handleTodoCreated processes a todo created event
 func (c *Cluster) handleTodoCreated(payload []byte) {
-	var event TodoSyncEvent
-	if err := json.Unmarshal(payload, &event); err != nil {
+	event, err := decodeSyncEvent(payload)
+	if err != nil {
 		log.Printf("❌ Failed to unmarshal todo created event: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("created").Inc()
 		return
 	}
 
 	// Skip if from myself
-	if event.NodeID == c.nodeID {
+	if c.isOwnEvent(event) {
 		return
 	}
+	metrics.SyncEventsReceived.WithLabelValues("created").Inc()
 
 	log.Printf("📥 Received todo created: %s from %s", event.ExternID, event.NodeID)
+	c.forgetFetched(todoKey(event.Namespace, event.ExternID))
+
+	// Drop events the API of this node would have rejected
+	if err := validateSyncEvent(event); err != nil {
+		log.Printf("❌ Dropping invalid todo created event from %s: %v", event.NodeID, err)
+		metrics.SyncEventsFailed.WithLabelValues("created").Inc()
+		return
+	}
+
+	// Merge the sender's logical time and drop changes older than the latest seen
+	c.clock.Witness(event.Lamport)
+	if !c.recordVersion(todoKey(event.Namespace, event.ExternID), versionOf(event)) {
+		log.Printf("⏭️  Todo %s created event is stale (lamport %d), skipping", event.ExternID, event.Lamport)
+		return
+	}
+
+	// Skip todos outside of this node's shards
+	if !c.ownsTodo(event.ExternID) {
+		log.Printf("⏭️  Todo %s not in owned shards, skipping", event.ExternID)
+		return
+	}
 
 	// Check if todo already exists (idempotency)
-	existing, err := c.db.GetTodoByExternID(event.ExternID)
+	existing, err := c.db.GetTodoByExternID(event.Namespace, event.ExternID)
 	if err != nil {
 		log.Printf("❌ Failed to check existing todo: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("created").Inc()
 		return
 	}
 
 	if existing != nil {
-		log.Printf("⏭️  Todo %s already exists, skipping", event.ExternID)
-		return
+		if existing.Todo == event.Todo && existing.Completed == eventCompleted(event) && sameMetadata(existing.Metadata, event.Metadata) {
+			log.Printf("⏭️  Todo %s already exists, skipping", event.ExternID)
+			return
+		}
+		// The same extern_id was created concurrently on another node. The
+		// event passed the version check, so it is newer than the local
+		// create and wins on every node.
+		log.Printf("⚔️  Todo %s was created concurrently by %s, taking its version", event.ExternID, event.NodeID)
 	}
 
-	// Create todo in local database
-	_, err = c.db.CreateTodo(event.ExternID, event.Todo)
+	// Create todo in local database, keeping the sender's completed state
+	_, err = c.db.UpsertTodo(event.Namespace, event.ExternID, event.Todo, event.Origin, eventCompleted(event), event.Metadata, event.ExpiresAt)
 	if err != nil {
 		log.Printf("❌ Failed to create todo: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("created").Inc()
 		return
 	}
 
 	log.Printf("✅ Todo %s synced successfully", event.ExternID)
+	metrics.SyncEventsApplied.WithLabelValues("created").Inc()
+	c.syncApplied(event)
 }
 
 // handleTodoUpdated processes a todo updated event
 func (c *Cluster) handleTodoUpdated(payload []byte) {
-	var event TodoSyncEvent
-	if err := json.Unmarshal(payload, &event); err != nil {
+	event, err := decodeSyncEvent(payload)
+	if err != nil {
 		log.Printf("❌ Failed to unmarshal todo updated event: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("updated").Inc()
 		return
 	}
 
 	// Skip if from myself
-	if event.NodeID == c.nodeID {
+	if c.isOwnEvent(event) {
 		return
 	}
+	metrics.SyncEventsReceived.WithLabelValues("updated").Inc()
 
 	log.Printf("📥 Received todo updated: %s from %s", event.ExternID, event.NodeID)
+	c.forgetFetched(todoKey(event.Namespace, event.ExternID))
+
+	// Drop events the API of this node would have rejected
+	if err := validateSyncEvent(event); err != nil {
+		log.Printf("❌ Dropping invalid todo updated event from %s: %v", event.NodeID, err)
+		metrics.SyncEventsFailed.WithLabelValues("updated").Inc()
+		return
+	}
+
+	// Merge the sender's logical time and drop changes older than the latest seen
+	c.clock.Witness(event.Lamport)
+	if !c.recordVersion(todoKey(event.Namespace, event.ExternID), versionOf(event)) {
+		log.Printf("⏭️  Todo %s updated event is stale (lamport %d), skipping", event.ExternID, event.Lamport)
+		return
+	}
+
+	// Skip todos outside of this node's shards
+	if !c.ownsTodo(event.ExternID) {
+		log.Printf("⏭️  Todo %s not in owned shards, skipping", event.ExternID)
+		return
+	}
 
 	// Find todo by extern_id
-	existing, err := c.db.GetTodoByExternID(event.ExternID)
+	existing, err := c.db.GetTodoByExternID(event.Namespace, event.ExternID)
 	if err != nil {
 		log.Printf("❌ Failed to find todo: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("updated").Inc()
 		return
 	}
 
 	if existing == nil {
-		// Todo doesn't exist, create it
+		// Todo doesn't exist (e.g. the update overtook the create), create it
+		// with the updated state including the completed flag
 		log.Printf("⚠️  Todo %s doesn't exist, creating", event.ExternID)
-		_, err = c.db.CreateTodo(event.ExternID, event.Todo)
+		_, err = c.db.UpsertTodo(event.Namespace, event.ExternID, event.Todo, event.Origin, eventCompleted(event), event.Metadata, event.ExpiresAt)
 		if err != nil {
 			log.Printf("❌ Failed to create todo: %v", err)
+			metrics.SyncEventsFailed.WithLabelValues("updated").Inc()
+			return
 		}
+		metrics.SyncEventsApplied.WithLabelValues("updated").Inc()
+		c.syncApplied(event)
+		return
+	}
+
+	if c.opts.ImmutableAfterCompletion && existing.Completed {
+		log.Printf("⏭️  Todo %s is completed and immutable, dropping updated event from %s", event.ExternID, event.NodeID)
 		return
 	}
 
@@ -151,34 +302,49 @@ func (c *Cluster) handleTodoUpdated(payload []byte) {
 		todo = &event.Todo
 	}
 
-	_, err = c.db.UpdateTodo(existing.ID, todo, event.Completed)
+	_, err = c.db.UpdateTodo(existing.ID, todo, event.Completed, event.Metadata, event.ExpiresAt)
 	if err != nil {
 		log.Printf("❌ Failed to update todo: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("updated").Inc()
 		return
 	}
 
 	log.Printf("✅ Todo %s updated successfully", event.ExternID)
+	metrics.SyncEventsApplied.WithLabelValues("updated").Inc()
+	c.syncApplied(event)
 }
 
 // handleTodoDeleted processes a todo deleted event
 func (c *Cluster) handleTodoDeleted(payload []byte) {
-	var event TodoSyncEvent
-	if err := json.Unmarshal(payload, &event); err != nil {
+	event, err := decodeSyncEvent(payload)
+	if err != nil {
 		log.Printf("❌ Failed to unmarshal todo deleted event: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("deleted").Inc()
 		return
 	}
 
 	// Skip if from myself
-	if event.NodeID == c.nodeID {
+	if c.isOwnEvent(event) {
 		return
 	}
+	metrics.SyncEventsReceived.WithLabelValues("deleted").Inc()
 
 	log.Printf("📥 Received todo deleted: %s from %s", event.ExternID, event.NodeID)
+	c.forgetFetched(todoKey(event.Namespace, event.ExternID))
+
+	// Merge the sender's logical time and drop changes older than the latest seen
+	c.clock.Witness(event.Lamport)
+	if !c.recordVersion(todoKey(event.Namespace, event.ExternID), versionOf(event)) {
+		log.Printf("⏭️  Todo %s deleted event is stale (lamport %d), skipping", event.ExternID, event.Lamport)
+		return
+	}
+	c.markDeleted(todoKey(event.Namespace, event.ExternID))
 
 	// Find todo by extern_id
-	existing, err := c.db.GetTodoByExternID(event.ExternID)
+	existing, err := c.db.GetTodoByExternID(event.Namespace, event.ExternID)
 	if err != nil {
 		log.Printf("❌ Failed to find todo: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("deleted").Inc()
 		return
 	}
 
@@ -191,8 +357,58 @@ func (c *Cluster) handleTodoDeleted(payload []byte) {
 	err = c.db.DeleteTodo(existing.ID)
 	if err != nil {
 		log.Printf("❌ Failed to delete todo: %v", err)
+		metrics.SyncEventsFailed.WithLabelValues("deleted").Inc()
 		return
 	}
 
 	log.Printf("✅ Todo %s deleted successfully", event.ExternID)
+	metrics.SyncEventsApplied.WithLabelValues("deleted").Inc()
+	c.syncApplied(event)
+}
+
+// decodeSyncEvent unmarshals a sync event in either encoding. Events from
+// nodes without namespace support belong to the default namespace.
+func decodeSyncEvent(payload []byte) (TodoSyncEvent, error) {
+	var event TodoSyncEvent
+	if err := unmarshalSyncEvent(payload, &event); err != nil {
+		return event, err
+	}
+	if event.Namespace == "" {
+		event.Namespace = models.DefaultNamespace
+	}
+	// Without an origin the sender is the best guess for created events
+	if event.Origin == "" && event.Type == "created" {
+		event.Origin = event.NodeID
+	}
+	return event, nil
+}
+
+// validateSyncEvent checks an event against the limits the API enforces
+// on todo input
+func validateSyncEvent(event TodoSyncEvent) error {
+	return validateTodoFields(event.ExternID, event.Todo, event.Metadata)
+}
+
+// validateTodoFields checks the extern_id and todo text length limits and
+// the metadata. An empty todo text is allowed, meaning unchanged in update
+// events.
+func validateTodoFields(externID, todo string, metadata models.Metadata) error {
+	if n := utf8.RuneCountInString(externID); n < 1 || n > models.MaxExternIDLength {
+		return fmt.Errorf("extern_id must be 1-%d characters, got %d", models.MaxExternIDLength, n)
+	}
+	if n := utf8.RuneCountInString(todo); n > models.MaxTodoLength {
+		return fmt.Errorf("todo must be at most %d characters, got %d", models.MaxTodoLength, n)
+	}
+	return metadata.Validate()
+}
+
+// sameMetadata returns true if applying incoming metadata would not change
+// current. Nil incoming metadata leaves it unchanged.
+func sameMetadata(current, incoming models.Metadata) bool {
+	return incoming == nil || maps.Equal(current, incoming)
+}
+
+// eventCompleted returns the completed flag of an event (false if unset)
+func eventCompleted(event TodoSyncEvent) bool {
+	return event.Completed != nil && *event.Completed
 }
@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncEventsStayInTheirNamespace(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+
+	send := func(handle func([]byte), event TodoSyncEvent) {
+		t.Helper()
+		event.NodeID = "node-b"
+		event.Timestamp = time.Now().Unix()
+		event.Lamport = c.tick()
+		payload, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		handle(payload)
+	}
+
+	for _, ns := range []string{"team-a", "team-b"} {
+		send(c.handleTodoCreated, TodoSyncEvent{Type: "created", Namespace: ns, ExternID: "todo-1", Todo: "Buy milk for " + ns})
+	}
+	send(c.handleTodoUpdated, TodoSyncEvent{Type: "updated", Namespace: "team-a", ExternID: "todo-1", Todo: "Buy oat milk for team-a"})
+	send(c.handleTodoDeleted, TodoSyncEvent{Type: "deleted", Namespace: "team-b", ExternID: "todo-1"})
+
+	todo, err := db.GetTodoByExternID("team-a", "todo-1")
+	if err != nil {
+		t.Fatalf("GetTodoByExternID: %v", err)
+	}
+	if todo == nil || todo.Todo != "Buy oat milk for team-a" {
+		t.Errorf("todo-1 in team-a = %+v, want the updated todo", todo)
+	}
+	if todo, err := db.GetTodoByExternID("team-b", "todo-1"); err != nil || todo != nil {
+		t.Errorf("todo-1 in team-b = %+v (%v), want deleted", todo, err)
+	}
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// sweepExpired periodically deletes expired todos and prunes the versions
+// of deleted ones until shutdown
+func (c *Cluster) sweepExpired() {
+	ticker := time.NewTicker(c.opts.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			c.deleteExpired()
+			c.pruneVersions(time.Now())
+		}
+	}
+}
+
+// deleteExpired deletes expired todos and broadcasts the deletions
+func (c *Cluster) deleteExpired() {
+	todos, err := c.db.ListExpiredTodos(time.Now(), c.opts.TTLAfterCreation, c.opts.TTLAfterCompletion)
+	if err != nil {
+		log.Printf("❌ Failed to list expired todos: %v", err)
+		return
+	}
+
+	for _, todo := range todos {
+		err := c.db.DeleteTodo(todo.ID)
+		if err == sql.ErrNoRows {
+			// Already deleted, e.g. by another node's sweep
+			continue
+		}
+		if err != nil {
+			log.Printf("❌ Failed to delete expired todo %s: %v", todo.ExternID, err)
+			continue
+		}
+
+		log.Printf("⌛ Todo %s expired, deleted", todo.ExternID)
+
+		if c.left.Load() {
+			c.markDeleted(todoKey(todo.Namespace, todo.ExternID))
+			continue
+		}
+		if err := c.BroadcastTodoDeleted(todo.Namespace, todo.ExternID); err != nil {
+			log.Printf("⚠️  Failed to broadcast expired todo %s: %v", todo.ExternID, err)
+		}
+	}
+}
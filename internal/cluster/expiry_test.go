@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestExpiredTodoDeletionPropagates(t *testing.T) {
+	a, dbA := startTestNode(t, "node-a")
+	_, dbB := startTestNode(t, "node-b", addrOf(a))
+
+	expired := time.Now().Add(-time.Minute)
+	if _, err := dbA.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-a", nil, &expired); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	if _, err := dbB.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-a", nil, &expired); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	a.deleteExpired()
+	if todo, _ := dbA.GetTodoByExternID(models.DefaultNamespace, "todo-1"); todo != nil {
+		t.Fatal("expired todo was not deleted")
+	}
+
+	// node-b only learns about the expiry from node-a's broadcast
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		todo, err := dbB.GetTodoByExternID(models.DefaultNamespace, "todo-1")
+		if err != nil {
+			t.Fatalf("GetTodoByExternID: %v", err)
+		}
+		if todo == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("deletion of the expired todo did not reach node-b")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestUpdatedEventAppliesExpiry(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+
+	if _, err := db.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-b", nil, nil); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	payload, err := json.Marshal(TodoSyncEvent{
+		Type:      "updated",
+		Namespace: models.DefaultNamespace,
+		ExternID:  "todo-1",
+		Todo:      "Buy oat milk",
+		ExpiresAt: &expiresAt,
+		NodeID:    "node-b",
+		Timestamp: time.Now().Unix(),
+		Lamport:   1,
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	c.handleTodoUpdated(payload)
+
+	todo, err := db.GetTodoByExternID(models.DefaultNamespace, "todo-1")
+	if err != nil {
+		t.Fatalf("GetTodoByExternID: %v", err)
+	}
+	if todo.Todo != "Buy oat milk" || todo.ExpiresAt == nil || !todo.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("todo after update = %q expiring at %v, want %q expiring at %v", todo.Todo, todo.ExpiresAt, "Buy oat milk", expiresAt)
+	}
+}
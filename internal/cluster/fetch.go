@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// fetchedTTL is how long a todo fetched from a peer is served from memory.
+	// Sync events for the todo invalidate it earlier.
+	fetchedTTL = 30 * time.Second
+
+	// maxFetched bounds the number of todos kept from peer fetches
+	maxFetched = 1024
+)
+
+// TodoStateRequest is the payload of a todo state query
+type TodoStateRequest struct {
+	Namespace string `json:"namespace"`
+	ExternID  string `json:"extern_id"`
+}
+
+// fetchedTodo is a todo fetched from a peer, kept until it expires
+type fetchedTodo struct {
+	todo    *models.Todo
+	expires time.Time
+}
+
+// FetchTodo looks up a todo missing from the local database on the other
+// nodes, if fetch on miss is enabled. Returns nil if it is disabled or no
+// node has the todo. Fetched todos are not stored in the database, since
+// this node would not receive their updates; they are kept in memory for
+// a short time instead.
+func (c *Cluster) FetchTodo(namespace, externID string) (*models.Todo, error) {
+	if !c.opts.FetchOnMiss || c.left.Load() {
+		return nil, nil
+	}
+
+	key := todoKey(namespace, externID)
+	c.fetchedMu.Lock()
+	entry, ok := c.fetched[key]
+	c.fetchedMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.todo, nil
+	}
+
+	payload, err := json.Marshal(TodoStateRequest{Namespace: namespace, ExternID: externID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal todo state query: %w", err)
+	}
+
+	resp, err := c.serf.Query(QueryTodoState, payload, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send todo state query: %w", err)
+	}
+	defer resp.Close()
+
+	// Nodes without the todo don't respond, so the first response wins
+	for r := range resp.ResponseCh() {
+		var todo models.Todo
+		if err := json.Unmarshal(r.Payload, &todo); err != nil {
+			log.Printf("❌ Failed to unmarshal todo state from %s: %v", r.From, err)
+			continue
+		}
+		if todo.Namespace != namespace || todo.ExternID != externID {
+			continue
+		}
+
+		log.Printf("📥 Fetched todo %s from %s", externID, r.From)
+		c.rememberFetched(key, &todo)
+		return &todo, nil
+	}
+
+	return nil, nil
+}
+
+// rememberFetched keeps a fetched todo in memory until fetchedTTL passes
+func (c *Cluster) rememberFetched(key string, todo *models.Todo) {
+	c.fetchedMu.Lock()
+	defer c.fetchedMu.Unlock()
+
+	now := time.Now()
+	if len(c.fetched) >= maxFetched {
+		for k, entry := range c.fetched {
+			if !now.Before(entry.expires) {
+				delete(c.fetched, k)
+			}
+		}
+		if len(c.fetched) >= maxFetched {
+			return
+		}
+	}
+	c.fetched[key] = fetchedTodo{todo: todo, expires: now.Add(fetchedTTL)}
+}
+
+// forgetFetched drops a fetched todo, e.g. because it changed
+func (c *Cluster) forgetFetched(key string) {
+	c.fetchedMu.Lock()
+	delete(c.fetched, key)
+	c.fetchedMu.Unlock()
+}
+
+// handleTodoStateQuery responds with a single todo if it is stored locally
+func (c *Cluster) handleTodoStateQuery(query *serf.Query) {
+	var req TodoStateRequest
+	if err := json.Unmarshal(query.Payload, &req); err != nil {
+		log.Printf("❌ Failed to unmarshal todo state query: %v", err)
+		return
+	}
+
+	todo, err := c.db.GetTodoByExternID(req.Namespace, req.ExternID)
+	if err != nil {
+		log.Printf("❌ Failed to get todo %s: %v", req.ExternID, err)
+		return
+	}
+	if todo == nil {
+		return
+	}
+
+	// Include the version of the latest change seen here, so repairers can
+	// tell whether their copy is newer
+	entry := fullStateTodo{Todo: todo}
+	if v, ok := c.knownVersion(todoKey(todo.Namespace, todo.ExternID)); ok {
+		entry.SyncVersion = &v
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("❌ Failed to marshal todo: %v", err)
+		return
+	}
+
+	if err := query.Respond(data); err != nil {
+		log.Printf("❌ Failed to respond to query: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestFetchTodoServesRememberedTodos(t *testing.T) {
+	c := &Cluster{fetched: make(map[string]fetchedTodo)}
+	c.opts.FetchOnMiss = true
+	key := todoKey("default", "todo-1")
+	c.rememberFetched(key, &models.Todo{Namespace: "default", ExternID: "todo-1", Todo: "Buy milk"})
+
+	// Served from memory; the cluster has no Serf instance to query
+	todo, err := c.FetchTodo("default", "todo-1")
+	if err != nil {
+		t.Fatalf("FetchTodo: %v", err)
+	}
+	if todo == nil || todo.Todo != "Buy milk" {
+		t.Fatalf("FetchTodo = %+v, want the remembered todo", todo)
+	}
+
+	c.forgetFetched(key)
+	if _, ok := c.fetched[key]; ok {
+		t.Fatal("todo still remembered after forgetFetched")
+	}
+
+	c.opts.FetchOnMiss = false
+	c.rememberFetched(key, todo)
+	if todo, err := c.FetchTodo("default", "todo-1"); todo != nil || err != nil {
+		t.Fatalf("FetchTodo with fetch on miss disabled = %+v, %v, want nil, nil", todo, err)
+	}
+}
+
+func TestRememberFetchedIsBounded(t *testing.T) {
+	c := &Cluster{fetched: make(map[string]fetchedTodo)}
+	for i := 0; i < maxFetched+10; i++ {
+		c.rememberFetched(fmt.Sprintf("default/todo-%d", i), &models.Todo{})
+	}
+	if len(c.fetched) != maxFetched {
+		t.Fatalf("remembered %d todos, want at most %d", len(c.fetched), maxFetched)
+	}
+
+	// Expired entries make room for new ones
+	for k, entry := range c.fetched {
+		entry.expires = time.Now().Add(-time.Second)
+		c.fetched[k] = entry
+		break
+	}
+	c.rememberFetched("default/new", &models.Todo{})
+	if _, ok := c.fetched["default/new"]; !ok {
+		t.Fatal("todo not remembered after an entry expired")
+	}
+}
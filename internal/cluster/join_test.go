@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// shortenJoinRetries speeds up the join retries for the duration of a test
+func shortenJoinRetries(t *testing.T) {
+	backoff, interval := joinRetryBackoff, graceRetryInterval
+	joinRetryBackoff, graceRetryInterval = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() {
+		joinRetryBackoff, graceRetryInterval = backoff, interval
+	})
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// newGraceNode creates a node with the given join grace period
+func newGraceNode(t *testing.T, grace time.Duration) *Cluster {
+	t.Helper()
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	c, err := New("node-a", "127.0.0.1:0", db, Options{LeaveTimeout: time.Second, JoinGracePeriod: grace})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.Stop(ctx)
+		db.Close()
+	})
+	return c
+}
+
+func TestStartWaitsGracePeriodForUnreachableSeeds(t *testing.T) {
+	shortenJoinRetries(t)
+	const grace = time.Second
+	c := newGraceNode(t, grace)
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.Start([]string{freeAddr(t)}, time.Second) }()
+
+	time.Sleep(grace / 2)
+	if c.IsReady() {
+		t.Fatal("node ready before the grace period passed")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after the grace period")
+	}
+	if elapsed := time.Since(started); elapsed < grace {
+		t.Errorf("Start returned after %v, before the grace period of %v", elapsed, grace)
+	}
+	if !c.IsReady() {
+		t.Error("node not ready after the grace period")
+	}
+}
+
+func TestStartJoinsSeedComingUpDuringGracePeriod(t *testing.T) {
+	shortenJoinRetries(t)
+	c := newGraceNode(t, 5*time.Second)
+	seedAddr := freeAddr(t)
+
+	done := make(chan error, 1)
+	go func() { done <- c.Start([]string{seedAddr}, 5*time.Second) }()
+
+	// The seed comes up after the initial join attempts failed
+	time.Sleep(500 * time.Millisecond)
+	if c.IsReady() {
+		t.Fatal("node ready before any seed was reachable")
+	}
+	seedDB := openTestDB(t, filepath.Join(t.TempDir(), "seed.db"))
+	seed, err := New("node-b", seedAddr, seedDB, Options{LeaveTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("New seed: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		seed.Stop(ctx)
+		seedDB.Close()
+	})
+	if err := seed.Start(nil, time.Second); err != nil {
+		t.Fatalf("Start seed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start did not return")
+	}
+	if !c.IsReady() {
+		t.Error("node not ready after joining")
+	}
+
+	var members []string
+	for _, member := range c.serf.Members() {
+		if member.Status == serf.StatusAlive {
+			members = append(members, member.Name)
+		}
+	}
+	if len(members) != 2 {
+		t.Errorf("alive members = %v, want node-a and node-b", members)
+	}
+}
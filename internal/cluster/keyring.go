@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+)
+
+// ErrEncryptionDisabled is returned by keyring operations when gossip
+// encryption is not enabled, so there is no keyring to manage
+var ErrEncryptionDisabled = errors.New("gossip encryption is not enabled")
+
+// ErrInvalidKey is returned by RotateKey for a malformed key
+var ErrInvalidKey = errors.New("invalid encryption key")
+
+// KeyringStatus is the keyring state reported by the cluster members.
+// Keys are identified by a fingerprint instead of the key itself.
+type KeyringStatus struct {
+	Keys        map[string]int    `json:"keys" doc:"Number of nodes holding each key, by key fingerprint"`
+	PrimaryKeys map[string]int    `json:"primary_keys" doc:"Number of nodes using each key as primary key, by key fingerprint"`
+	Nodes       int               `json:"nodes" doc:"Number of nodes known to the cluster"`
+	Responses   int               `json:"responses" doc:"Number of nodes that responded"`
+	Errors      map[string]string `json:"errors,omitempty" doc:"Error messages by node"`
+}
+
+// Keyring lists the keys installed on the cluster members
+func (c *Cluster) Keyring() (*KeyringStatus, error) {
+	if !c.serf.EncryptionEnabled() {
+		return nil, ErrEncryptionDisabled
+	}
+
+	resp, err := c.serf.KeyManager().ListKeys()
+	if err != nil {
+		return keyringStatus(resp), fmt.Errorf("failed to list keys: %w", err)
+	}
+	return keyringStatus(resp), nil
+}
+
+// RotateKey replaces the gossip encryption key on all members: the base64
+// key is installed, made the primary key and all other keys are removed.
+// Every step must succeed on all members before the next one runs, so a
+// failed rotation leaves a keyring the whole cluster can still talk with.
+// With a keyring file, every member persists its new keyring.
+func (c *Cluster) RotateKey(key string) (*KeyringStatus, error) {
+	if !c.serf.EncryptionEnabled() {
+		return nil, ErrEncryptionDisabled
+	}
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	removed, resp, err := rotateKey(c.serf.KeyManager(), key)
+	if err != nil {
+		return keyringStatus(resp), err
+	}
+
+	log.Printf("🔑 Rotated gossip encryption key to %s, removed %d old keys", keyFingerprint(key), removed)
+	return c.Keyring()
+}
+
+// keyManager is the part of Serf's key manager used for key rotation
+type keyManager interface {
+	ListKeys() (*serf.KeyResponse, error)
+	InstallKey(key string) (*serf.KeyResponse, error)
+	UseKey(key string) (*serf.KeyResponse, error)
+	RemoveKey(key string) (*serf.KeyResponse, error)
+}
+
+// rotateKey installs key, makes it primary and removes all other keys,
+// stopping at the first failed step. Returns the number of removed keys,
+// or the response of the failed step.
+func rotateKey(manager keyManager, key string) (int, *serf.KeyResponse, error) {
+	resp, err := manager.ListKeys()
+	if err != nil {
+		return 0, resp, fmt.Errorf("failed to list keys: %w", err)
+	}
+	var oldKeys []string
+	for oldKey := range resp.Keys {
+		if oldKey != key {
+			oldKeys = append(oldKeys, oldKey)
+		}
+	}
+
+	if resp, err := manager.InstallKey(key); err != nil {
+		return 0, resp, fmt.Errorf("failed to install key: %w", err)
+	}
+	if resp, err := manager.UseKey(key); err != nil {
+		return 0, resp, fmt.Errorf("failed to make key primary: %w", err)
+	}
+	for i, oldKey := range oldKeys {
+		if resp, err := manager.RemoveKey(oldKey); err != nil {
+			return i, resp, fmt.Errorf("failed to remove key %s: %w", keyFingerprint(oldKey), err)
+		}
+	}
+	return len(oldKeys), nil, nil
+}
+
+// loadKeyring reads the keyring Serf persisted to path on key changes, a
+// JSON list of base64 keys with the primary key first. Returns nil if the
+// file does not exist yet, i.e. no key was rotated.
+func loadKeyring(path string) (*memberlist.Keyring, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %w", err)
+	}
+
+	var encoded []string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s: %w", path, err)
+	}
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("invalid keyring file %s: no keys", path)
+	}
+
+	keys := make([][]byte, len(encoded))
+	for i, key := range encoded {
+		if err := validateKey(key); err != nil {
+			return nil, fmt.Errorf("invalid keyring file %s: %w", path, err)
+		}
+		keys[i], _ = base64.StdEncoding.DecodeString(key)
+	}
+	return memberlist.NewKeyring(keys, keys[0])
+}
+
+// validateKey checks that a key is base64 and of a size Serf accepts
+func validateKey(key string) error {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("%w: not valid base64", ErrInvalidKey)
+	}
+	if err := memberlist.ValidateKey(raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return nil
+}
+
+// keyFingerprint identifies a base64 key without revealing it
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// keyringStatus converts a Serf key response, which may be nil
+func keyringStatus(resp *serf.KeyResponse) *KeyringStatus {
+	status := &KeyringStatus{
+		Keys:        make(map[string]int),
+		PrimaryKeys: make(map[string]int),
+	}
+	if resp == nil {
+		return status
+	}
+
+	for key, count := range resp.Keys {
+		status.Keys[keyFingerprint(key)] = count
+	}
+	for key, count := range resp.PrimaryKeys {
+		status.PrimaryKeys[keyFingerprint(key)] = count
+	}
+	status.Nodes = resp.NumNodes
+	status.Responses = resp.NumResp
+	for node, msg := range resp.Messages {
+		if msg != "" {
+			if status.Errors == nil {
+				status.Errors = make(map[string]string)
+			}
+			status.Errors[node] = msg
+		}
+	}
+	return status
+}
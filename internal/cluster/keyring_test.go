@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+)
+
+// fakeKeyManager records the key operations and fails the one named in fail
+type fakeKeyManager struct {
+	keys  []string
+	fail  string
+	calls []string
+}
+
+func (m *fakeKeyManager) do(op, key string) (*serf.KeyResponse, error) {
+	m.calls = append(m.calls, op+" "+key)
+	if op == m.fail {
+		return &serf.KeyResponse{Messages: map[string]string{"node-b": "failed"}}, errors.New("1/2 nodes reported failure")
+	}
+	return &serf.KeyResponse{}, nil
+}
+
+func (m *fakeKeyManager) ListKeys() (*serf.KeyResponse, error) {
+	resp := &serf.KeyResponse{Keys: make(map[string]int)}
+	for _, key := range m.keys {
+		resp.Keys[key] = 2
+	}
+	return resp, nil
+}
+
+func (m *fakeKeyManager) InstallKey(key string) (*serf.KeyResponse, error) {
+	return m.do("install", key)
+}
+func (m *fakeKeyManager) UseKey(key string) (*serf.KeyResponse, error)    { return m.do("use", key) }
+func (m *fakeKeyManager) RemoveKey(key string) (*serf.KeyResponse, error) { return m.do("remove", key) }
+
+func TestRotateKeySequence(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      string
+		wantCalls []string
+		wantErr   bool
+	}{
+		{"success", "", []string{"install new", "use new", "remove old"}, false},
+		{"install fails", "install", []string{"install new"}, true},
+		{"use fails", "use", []string{"install new", "use new"}, true},
+		{"remove fails", "remove", []string{"install new", "use new", "remove old"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := &fakeKeyManager{keys: []string{"old", "new"}, fail: tt.fail}
+			removed, resp, err := rotateKey(manager, "new")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rotateKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(manager.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", manager.calls, tt.wantCalls)
+			}
+			if err != nil && resp == nil {
+				t.Error("no response of the failed step")
+			}
+			if err == nil && removed != 1 {
+				t.Errorf("removed %d keys, want 1", removed)
+			}
+		})
+	}
+}
+
+func TestRotatedKeySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	newKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	oldRaw, _ := base64.StdEncoding.DecodeString(oldKey)
+	opts := Options{
+		LeaveTimeout: time.Second,
+		EncryptKey:   oldRaw,
+		KeyringFile:  filepath.Join(dir, "todos.keyring"),
+	}
+
+	start := func() *Cluster {
+		db := openTestDB(t, filepath.Join(dir, "todos.db"))
+		c, err := New("node-a", "127.0.0.1:0", db, opts)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if err := c.Start(nil, time.Second); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		return c
+	}
+	stop := func(c *Cluster) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := c.Stop(ctx); err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+		c.db.Close()
+	}
+
+	c := start()
+	if _, err := c.RotateKey(newKey); err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	stop(c)
+
+	// Restarted with the outdated configured key, the node uses the
+	// rotated key from the keyring file
+	c = start()
+	defer stop(c)
+	status, err := c.Keyring()
+	if err != nil {
+		t.Fatalf("Keyring: %v", err)
+	}
+	want := map[string]int{keyFingerprint(newKey): 1}
+	if !reflect.DeepEqual(status.Keys, want) || !reflect.DeepEqual(status.PrimaryKeys, want) {
+		t.Errorf("keyring after restart = %+v, want only the rotated key %v", status, want)
+	}
+}
+
+func TestLoadKeyringRejectsInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.keyring")
+	if keyring, err := loadKeyring(path); keyring != nil || err != nil {
+		t.Fatalf("loadKeyring of a missing file = %v, %v, want nil, nil", keyring, err)
+	}
+
+	for _, data := range []string{`[]`, `["not base64"]`, `{`} {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := loadKeyring(path); err == nil {
+			t.Errorf("loadKeyring accepted %s", data)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/metrics"
+)
+
+const (
+	// outboxInterval is how often due broadcasts are retried
+	outboxInterval = time.Second
+
+	// Retry backoff doubles from outboxMinBackoff up to outboxMaxBackoff
+	outboxMinBackoff = time.Second
+	outboxMaxBackoff = 30 * time.Second
+)
+
+// errStopped is the send error of broadcasts made after Stop; they are
+// queued and sent after the next start
+var errStopped = errors.New("cluster is stopped")
+
+// restoreOutbox loads the todos with broadcasts queued before a restart
+func (c *Cluster) restoreOutbox() error {
+	pending, err := c.db.PendingBroadcasts()
+	if err != nil {
+		return fmt.Errorf("failed to restore broadcast outbox: %w", err)
+	}
+	for _, b := range pending {
+		c.outboxKeys[b.Key] = true
+	}
+	metrics.BroadcastsQueued.Set(float64(len(c.outboxKeys)))
+	return nil
+}
+
+// queueBroadcast persists a failed broadcast for retrying. A queued event
+// for the same todo is replaced, since the newer event supersedes it.
+func (c *Cluster) queueBroadcast(key, name string, payload []byte, coalesce bool) error {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+
+	now := time.Now()
+	err := c.db.QueueBroadcast(database.PendingBroadcast{
+		Key:         key,
+		Name:        name,
+		Payload:     payload,
+		Coalesce:    coalesce,
+		QueuedAt:    now,
+		Attempts:    1,
+		NextAttempt: now.Add(outboxMinBackoff),
+	})
+	if err != nil {
+		return err
+	}
+	c.outboxKeys[key] = true
+	metrics.BroadcastsQueued.Set(float64(len(c.outboxKeys)))
+	return nil
+}
+
+// dequeueBroadcast removes a queued broadcast once it, or a newer event
+// for the same todo (payload nil), was broadcast
+func (c *Cluster) dequeueBroadcast(key string, payload []byte) {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+
+	if !c.outboxKeys[key] {
+		return
+	}
+	empty, err := c.db.DeleteBroadcast(key, payload)
+	if err != nil {
+		log.Printf("❌ Failed to remove queued broadcast: %v", err)
+		return
+	}
+	if empty {
+		delete(c.outboxKeys, key)
+	}
+	metrics.BroadcastsQueued.Set(float64(len(c.outboxKeys)))
+}
+
+// retryBroadcasts resends failed broadcasts, including those queued before
+// a restart, once the node is ready and then periodically until shutdown
+func (c *Cluster) retryBroadcasts() {
+	select {
+	case <-c.shutdown:
+		return
+	case <-c.readyCh:
+	}
+	c.retryDueBroadcasts(time.Now())
+
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case now := <-ticker.C:
+			c.retryDueBroadcasts(now)
+		}
+	}
+}
+
+// retryDueBroadcasts resends the queued broadcasts whose backoff has passed
+// and drops those older than BroadcastRetryMaxAge
+func (c *Cluster) retryDueBroadcasts(now time.Time) {
+	if c.left.Load() {
+		return
+	}
+
+	c.outboxMu.Lock()
+	empty := len(c.outboxKeys) == 0
+	c.outboxMu.Unlock()
+	if empty {
+		return
+	}
+
+	pending, err := c.db.PendingBroadcasts()
+	if err != nil {
+		log.Printf("❌ Failed to list queued broadcasts: %v", err)
+		return
+	}
+
+	for _, b := range pending {
+		if now.Sub(b.QueuedAt) > c.opts.BroadcastRetryMaxAge {
+			log.Printf("🗑️  Giving up on broadcasting %s for %s after %d attempts", b.Name, b.Key, b.Attempts)
+			c.dequeueBroadcast(b.Key, b.Payload)
+			metrics.BroadcastsDropped.Inc()
+			continue
+		}
+		if now.Before(b.NextAttempt) {
+			continue
+		}
+
+		if err := c.userEvent(b.Name, b.Payload, b.Coalesce); err != nil {
+			backoff := min(outboxMinBackoff<<min(b.Attempts, 5), outboxMaxBackoff)
+			if err := c.db.RescheduleBroadcast(b.Key, b.Payload, b.Attempts+1, now.Add(backoff)); err != nil {
+				log.Printf("❌ %v", err)
+			}
+			continue
+		}
+
+		c.dequeueBroadcast(b.Key, b.Payload)
+		metrics.SyncBytesBroadcast.WithLabelValues(eventKind(b.Name)).Add(float64(len(b.Payload)))
+		log.Printf("📤 Broadcasted %s for %s (attempt %d)", b.Name, b.Key, b.Attempts+1)
+
+		// Events queued before a restart are not in the versions map yet
+		if event, err := decodeSyncEvent(b.Payload); err == nil {
+			c.recordBroadcast(b.Name, event)
+		}
+	}
+}
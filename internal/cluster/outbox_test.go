@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// sentEvent is a user event passed to a stubbed userEvent
+type sentEvent struct {
+	name     string
+	payload  []byte
+	coalesce bool
+}
+
+// stubUserEvent replaces Serf's UserEvent, failing while *fail is set and
+// recording the events it accepts
+func stubUserEvent(c *Cluster, fail *bool) *[]sentEvent {
+	var sent []sentEvent
+	c.userEvent = func(name string, payload []byte, coalesce bool) error {
+		if *fail {
+			return errors.New("send failed")
+		}
+		sent = append(sent, sentEvent{name: name, payload: payload, coalesce: coalesce})
+		return nil
+	}
+	return &sent
+}
+
+func TestOutboxRetriesFailedBroadcast(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	fail := true
+	sent := stubUserEvent(c, &fail)
+
+	todo := &models.Todo{Namespace: "default", ExternID: "todo-1", Todo: "Buy milk"}
+	if err := c.BroadcastTodoCreated(todo); err == nil {
+		t.Fatal("BroadcastTodoCreated succeeded although the send failed")
+	}
+	pending, err := db.PendingBroadcasts()
+	if err != nil {
+		t.Fatalf("PendingBroadcasts: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("%d broadcasts queued, want 1", len(pending))
+	}
+
+	// Not retried before the backoff passed
+	fail = false
+	c.retryDueBroadcasts(time.Now())
+	if len(*sent) != 0 {
+		t.Fatalf("retried before the backoff passed")
+	}
+
+	c.retryDueBroadcasts(time.Now().Add(outboxMinBackoff))
+	if len(*sent) != 1 || (*sent)[0].name != EventTodoCreated {
+		t.Fatalf("sent %+v, want the queued created event", *sent)
+	}
+	if pending, _ := db.PendingBroadcasts(); len(pending) != 0 {
+		t.Errorf("%d broadcasts still queued after a successful retry", len(pending))
+	}
+	if len(c.outboxKeys) != 0 {
+		t.Errorf("outbox keys = %v after a successful retry, want none", c.outboxKeys)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+	db := openTestDB(t, path)
+	c := newTestCluster(t, db)
+	fail := true
+	stubUserEvent(c, &fail)
+
+	if err := c.BroadcastTodoDeleted("default", "todo-1"); err == nil {
+		t.Fatal("BroadcastTodoDeleted succeeded although the send failed")
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	db = openTestDB(t, path)
+	defer db.Close()
+	restarted := newTestCluster(t, db)
+	fail = false
+	sent := stubUserEvent(restarted, &fail)
+
+	restarted.retryDueBroadcasts(time.Now().Add(outboxMinBackoff))
+	if len(*sent) != 1 || (*sent)[0].name != EventTodoDeleted {
+		t.Fatalf("sent %+v after restart, want the queued deleted event", *sent)
+	}
+	// The sent event is the latest change known for the todo
+	if _, ok := restarted.knownVersion(todoKey("default", "todo-1")); !ok {
+		t.Error("version of the retried event was not recorded")
+	}
+}
+
+func TestOutboxKeepsOnlyLatestEventPerTodo(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	fail := true
+	sent := stubUserEvent(c, &fail)
+
+	todo := &models.Todo{Namespace: "default", ExternID: "todo-1", Todo: "first"}
+	c.BroadcastTodoCreated(todo)
+	todo.Todo = "second"
+	c.BroadcastTodoUpdated(todo)
+
+	pending, _ := db.PendingBroadcasts()
+	if len(pending) != 1 || pending[0].Name != EventTodoUpdated {
+		t.Fatalf("queued %+v, want only the updated event", pending)
+	}
+
+	// A newer successful broadcast supersedes the queued event
+	fail = false
+	todo.Todo = "third"
+	if err := c.BroadcastTodoUpdated(todo); err != nil {
+		t.Fatalf("BroadcastTodoUpdated: %v", err)
+	}
+	if pending, _ := db.PendingBroadcasts(); len(pending) != 0 {
+		t.Errorf("%d broadcasts still queued after a newer broadcast", len(pending))
+	}
+	c.retryDueBroadcasts(time.Now().Add(time.Hour))
+	if len(*sent) != 1 {
+		t.Errorf("sent %d events, want only the newest", len(*sent))
+	}
+}
+
+func TestOutboxDropsExpiredBroadcasts(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	fail := true
+	sent := stubUserEvent(c, &fail)
+
+	c.BroadcastTodoDeleted("default", "todo-1")
+	fail = false
+	c.retryDueBroadcasts(time.Now().Add(c.opts.BroadcastRetryMaxAge + time.Second))
+
+	if len(*sent) != 0 {
+		t.Errorf("sent %d events older than the maximum age", len(*sent))
+	}
+	if pending, _ := db.PendingBroadcasts(); len(pending) != 0 {
+		t.Errorf("%d expired broadcasts still queued", len(pending))
+	}
+}
+
+func TestOversizedBroadcastIsNotQueued(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	fail := false
+	sent := stubUserEvent(c, &fail)
+
+	todo := &models.Todo{Namespace: "default", ExternID: "todo-1", Todo: strings.Repeat("x", 500)}
+	if err := c.BroadcastTodoCreated(todo); !errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("err = %v, want ErrEventTooLarge", err)
+	}
+	if len(*sent) != 0 {
+		t.Error("oversized event was sent")
+	}
+	if pending, _ := db.PendingBroadcasts(); len(pending) != 0 {
+		t.Error("oversized event was queued")
+	}
+	if _, ok := c.knownVersion(todoKey("default", "todo-1")); ok {
+		t.Error("version of the rejected event was recorded")
+	}
+}
+
+func TestFailedSendIsNotReportedAsTooLarge(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	fail := true
+	stubUserEvent(c, &fail)
+
+	err := c.BroadcastTodoDeleted("default", "todo-1")
+	if err == nil || errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("err = %v, want a send error other than ErrEventTooLarge", err)
+	}
+}
@@ -2,37 +2,106 @@ package cluster
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"time"
 
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/metrics"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
 	"github.com/hashicorp/serf/serf"
 )
 
+const (
+	// fullStateQueryTimeout bounds the wait for a single full state page
+	fullStateQueryTimeout = 10 * time.Second
+
+	// freshnessQueryTimeout bounds the wait for peers to report their
+	// freshness before electing a full sync responder
+	freshnessQueryTimeout = 2 * time.Second
+
+	// queryResponseOverhead is room left in a query response for Serf's
+	// message header, in addition to the node name
+	queryResponseOverhead = 64
+)
+
 // handleQuery handles incoming Serf queries
 func (c *Cluster) handleQuery(query *serf.Query) {
 	switch query.Name {
 	case QueryFullState:
-		c.handleFullStateQuery(query)
+		// Listing all todos can be slow, so don't block the event handler
+		c.goLoop(func() {
+			if !c.acquireSyncSlot(query.Deadline()) {
+				log.Printf("⏭️  Too many concurrent full syncs, not answering %s", query.SourceNode())
+				metrics.FullStateQueriesRejected.Inc()
+				return
+			}
+			defer c.releaseSyncSlot()
+			c.handleFullStateQuery(query)
+		})
 	case QueryCount:
 		c.handleCountQuery(query)
+	case QueryTodoState:
+		c.handleTodoStateQuery(query)
+	case QueryRepair:
+		c.handleRepairQuery(query)
+	case QueryFreshness:
+		c.handleFreshnessQuery(query)
 	default:
 		log.Printf("Unknown query: %s", query.Name)
 	}
 }
 
-// handleFullStateQuery responds with all todos in the database
+// acquireSyncSlot waits for one of the MaxConcurrentSyncs slots for
+// answering a full state query. Returns false if none became free before
+// the deadline, when the requester no longer accepts the response.
+func (c *Cluster) acquireSyncSlot(deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case c.syncSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.shutdown:
+		return false
+	}
+}
+
+// releaseSyncSlot frees a slot taken by acquireSyncSlot
+func (c *Cluster) releaseSyncSlot() {
+	<-c.syncSlots
+}
+
+// handleFullStateQuery responds with a page of todos that fits the query
+// response size limit
 func (c *Cluster) handleFullStateQuery(query *serf.Query) {
-	log.Printf("📤 Received full state query from %s", query.SourceNode())
+	var req FullStateRequest
+	legacy := len(query.Payload) == 0
+	if !legacy {
+		if err := json.Unmarshal(query.Payload, &req); err != nil {
+			log.Printf("❌ Failed to unmarshal full state query: %v", err)
+			return
+		}
+	}
 
-	// Get all todos from database
-	todos, err := c.db.ListTodos()
+	page, err := c.fullStatePage(req.AfterID)
 	if err != nil {
 		log.Printf("❌ Failed to list todos: %v", err)
 		return
 	}
 
-	// Marshal todos to JSON
-	data, err := json.Marshal(todos)
+	// Nodes without paging send no payload and expect a plain array. They
+	// only receive the first page.
+	var data []byte
+	if legacy {
+		data, err = json.Marshal(page.Todos)
+	} else {
+		data, err = json.Marshal(page)
+	}
 	if err != nil {
 		log.Printf("❌ Failed to marshal todos: %v", err)
 		return
@@ -44,7 +113,68 @@ func (c *Cluster) handleFullStateQuery(query *serf.Query) {
 		return
 	}
 
-	log.Printf("✅ Sent %d todos to %s", len(todos), query.SourceNode())
+	log.Printf("✅ Sent %d todos after id %d to %s", len(page.Todos), req.AfterID, query.SourceNode())
+}
+
+// pageBudget returns the bytes available for todos in a full state page,
+// leaving room for Serf's message header and the page envelope
+func (c *Cluster) pageBudget() int {
+	envelope, _ := json.Marshal(FullStateResponse{Todos: []json.RawMessage{}, NextAfterID: math.MaxInt})
+	return c.opts.QueryResponseSizeLimit - queryResponseOverhead - len(c.nodeID) - len(envelope)
+}
+
+// fullStateTodo is a todo in a full state page, with the version of the
+// latest change to it the responder has seen, if any
+type fullStateTodo struct {
+	*models.Todo
+	SyncVersion *eventVersion `json:"sync_version,omitempty"`
+}
+
+// errPageFull stops reading todos once a full state page is full
+var errPageFull = errors.New("page full")
+
+// fullStatePage packs as many todos with an id greater than afterID into a
+// page as fit the query response size limit
+func (c *Cluster) fullStatePage(afterID int) (FullStateResponse, error) {
+	page := FullStateResponse{Todos: []json.RawMessage{}}
+	budget := c.pageBudget()
+
+	size, lastID := 0, afterID
+	err := c.db.EachTodo(database.ListOptions{AfterID: afterID, SortBy: "id", Order: "asc", Primary: true}, func(todo *models.Todo) error {
+		entry := fullStateTodo{Todo: todo}
+		if v, ok := c.knownVersion(todoKey(todo.Namespace, todo.ExternID)); ok {
+			entry.SyncVersion = &v
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if len(data) > budget {
+			// The API rejects such todos (see CheckTodoSize), so only todos
+			// stored before or under larger limits end up here
+			log.Printf("⚠️  Todo %s exceeds the query response size limit, not sending it", todo.ExternID)
+			lastID = todo.ID
+			return nil
+		}
+		// All but the first entry are preceded by a comma, so an entry
+		// that passed the check above always fits an empty page
+		sep := 0
+		if len(page.Todos) > 0 {
+			sep = 1
+		}
+		if size+sep+len(data) > budget {
+			page.NextAfterID = lastID
+			return errPageFull
+		}
+		page.Todos = append(page.Todos, data)
+		size += sep + len(data)
+		lastID = todo.ID
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return FullStateResponse{}, err
+	}
+	return page, nil
 }
 
 // handleCountQuery responds with the count of todos
@@ -79,74 +209,308 @@ func (c *Cluster) handleCountQuery(query *serf.Query) {
 	log.Printf("✅ Sent count (%d) to %s", count, query.SourceNode())
 }
 
-// requestFullSync requests full state from all nodes in the cluster
+// handleFreshnessQuery responds with when this node's todos last changed
+func (c *Cluster) handleFreshnessQuery(query *serf.Query) {
+	lastUpdatedAt, err := c.db.LastUpdatedAt()
+	if err != nil {
+		log.Printf("❌ Failed to get last update time: %v", err)
+		return
+	}
+
+	data, err := json.Marshal(FreshnessResponse{NodeID: c.nodeID, LastUpdatedAt: lastUpdatedAt})
+	if err != nil {
+		log.Printf("❌ Failed to marshal freshness response: %v", err)
+		return
+	}
+
+	if err := query.Respond(data); err != nil {
+		log.Printf("❌ Failed to respond to query: %v", err)
+	}
+}
+
+// ErrSyncInProgress is returned by Resync while another full sync runs
+var ErrSyncInProgress = errors.New("full sync already in progress")
+
+// SyncResult summarizes a full sync
+type SyncResult struct {
+	Synced     int // todos created locally
+	Reconciled int // existing todos updated to the received state
+}
+
+// requestFullSync requests full state from the cluster and marks the node
+// ready when done
 func (c *Cluster) requestFullSync() {
+	// Only one full sync at a time; a redundant request (e.g. from a
+	// flapping join) must not mark the node ready before the running one
+	if !c.syncing.CompareAndSwap(false, true) {
+		log.Println("⏭️  Full sync already in progress, ignoring request")
+		return
+	}
+	defer c.syncing.Store(false)
+
 	defer c.markReady() // Always mark as ready when done, even on error
 
-	log.Println("🔄 Requesting full sync from cluster...")
+	c.fullSync()
+}
+
+// Resync runs a full sync on demand, e.g. after detecting divergence.
+// Returns ErrSyncInProgress if a full sync is already running.
+func (c *Cluster) Resync() (SyncResult, error) {
+	if c.left.Load() {
+		return SyncResult{}, fmt.Errorf("cannot resync: node has left the cluster")
+	}
+	if !c.syncing.CompareAndSwap(false, true) {
+		return SyncResult{}, ErrSyncInProgress
+	}
+	defer c.syncing.Store(false)
+
+	return c.fullSync(), nil
+}
+
+// fullSync requests full state from the cluster. A single elected responder
+// serves the sync so the data is not received once per member.
+func (c *Cluster) fullSync() SyncResult {
+	seenExternIDs := make(map[string]bool)
+	var result SyncResult
+
+	var sources []string
+	if responder := c.syncResponder(); responder != "" {
+		log.Printf("🔄 Requesting full sync from %s...", responder)
+		if c.syncFrom(responder, seenExternIDs, &result) {
+			log.Printf("✅ Full sync complete: %d todos synced, %d reconciled", result.Synced, result.Reconciled)
+			c.lastSyncAt.Store(time.Now().UnixNano())
+			return result
+		}
+
+		// Fall back to all nodes if the elected responder did not answer
+		log.Printf("⚠️  No full sync response from %s, requesting from all nodes", responder)
+		for _, peer := range c.alivePeers() {
+			if peer != responder {
+				sources = append(sources, peer)
+			}
+		}
+	} else {
+		log.Println("🔄 Requesting full sync from cluster...")
+		sources = c.alivePeers()
+	}
 
-	// Create query params
-	params := &serf.QueryParam{
-		FilterNodes: nil, // Query all nodes
-		RequestAck:  true,
-		Timeout:     10 * time.Second,
+	for _, peer := range sources {
+		c.syncFrom(peer, seenExternIDs, &result)
 	}
 
-	// Send query
-	resp, err := c.serf.Query(QueryFullState, nil, params)
+	log.Printf("✅ Full sync complete: %d todos synced, %d reconciled", result.Synced, result.Reconciled)
+	c.lastSyncAt.Store(time.Now().UnixNano())
+	return result
+}
+
+// syncResponder elects the member that serves a full sync: the alive peer
+// whose todos changed most recently, so a lagging peer doesn't hand out
+// stale data. Ties, and peers that don't report their freshness, go to the
+// lowest name. Returns "" if all nodes should respond, which is the case
+// with sharding since each node only holds part of the data.
+func (c *Cluster) syncResponder() string {
+	if c.opts.TotalShards > 0 {
+		return ""
+	}
+
+	peers := c.alivePeers()
+	if len(peers) == 0 {
+		return ""
+	}
+	freshness := c.queryFreshness(peers)
+
+	responder := ""
+	var responderUpdatedAt time.Time
+	for _, peer := range peers {
+		updatedAt := freshness[peer]
+		if responder == "" || updatedAt.After(responderUpdatedAt) ||
+			(updatedAt.Equal(responderUpdatedAt) && peer < responder) {
+			responder, responderUpdatedAt = peer, updatedAt
+		}
+	}
+	return responder
+}
+
+// queryFreshness asks peers when their todos last changed. Peers without
+// todos, and peers that don't answer in time, are missing from the result.
+func (c *Cluster) queryFreshness(peers []string) map[string]time.Time {
+	freshness := make(map[string]time.Time, len(peers))
+
+	resp, err := c.serf.Query(QueryFreshness, nil, &serf.QueryParam{
+		FilterNodes: peers,
+		Timeout:     freshnessQueryTimeout,
+	})
 	if err != nil {
-		log.Printf("❌ Failed to send full sync query: %v", err)
-		return
+		log.Printf("⚠️  Failed to query peer freshness: %v", err)
+		return freshness
 	}
+	defer resp.Close()
 
-	// Collect responses
-	seenExternIDs := make(map[string]bool)
-	totalSynced := 0
+	answered := 0
+	for r := range resp.ResponseCh() {
+		answered++
+		var response FreshnessResponse
+		if err := json.Unmarshal(r.Payload, &response); err != nil {
+			log.Printf("❌ Failed to unmarshal freshness from %s: %v", r.From, err)
+		} else if response.LastUpdatedAt != nil {
+			freshness[r.From] = *response.LastUpdatedAt
+		}
+		if answered == len(peers) {
+			break
+		}
+	}
+	return freshness
+}
+
+// syncedTodo is a todo as received in a full state page or todo state
+// query response
+type syncedTodo struct {
+	Namespace string          `json:"namespace"`
+	ExternID  string          `json:"extern_id"`
+	Todo      string          `json:"todo"`
+	Completed bool            `json:"completed"`
+	ExpiresAt *time.Time      `json:"expires_at"`
+	Origin    string          `json:"origin_node"`
+	Metadata  models.Metadata `json:"metadata"`
+	UpdatedAt *time.Time      `json:"updated_at"` // responder's last change, nil from older nodes
+
+	// Version of the latest change the responder has seen, nil if it has
+	// seen none since its start or the responder predates versions
+	SyncVersion *eventVersion `json:"sync_version"`
+}
+
+// syncFrom pages through the todos of a single node and applies them,
+// adding to result. Todos in seenExternIDs are skipped. Returns false if
+// the node did not answer the first page.
+func (c *Cluster) syncFrom(node string, seenExternIDs map[string]bool, result *SyncResult) bool {
+	afterID := 0
+	for pages := 0; ; pages++ {
+		todos, nextAfterID, err := c.queryFullStatePage(node, afterID)
+		if err != nil {
+			log.Printf("❌ Full sync from %s failed: %v", node, err)
+			return pages > 0
+		}
+
+		log.Printf("📦 Received %d todos from %s", len(todos), node)
+		c.applySyncedTodos(node, todos, seenExternIDs, result)
+
+		if nextAfterID == 0 {
+			return true
+		}
+		afterID = nextAfterID
+	}
+}
+
+// queryFullStatePage requests the page of todos after afterID from a node.
+// Returns the todos and the id to request the next page after, 0 if this
+// was the last page.
+func (c *Cluster) queryFullStatePage(node string, afterID int) ([]syncedTodo, int, error) {
+	payload, err := json.Marshal(FullStateRequest{AfterID: afterID})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal full state query: %w", err)
+	}
+
+	resp, err := c.serf.Query(QueryFullState, payload, &serf.QueryParam{
+		FilterNodes: []string{node},
+		Timeout:     fullStateQueryTimeout,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send full state query: %w", err)
+	}
+	defer resp.Close()
 
 	for r := range resp.ResponseCh() {
-		var todos []struct {
-			ExternID  string `json:"extern_id"`
-			Todo      string `json:"todo"`
-			Completed bool   `json:"completed"`
+		// Nodes without paging respond with a plain array of all todos
+		if len(r.Payload) > 0 && r.Payload[0] == '[' {
+			var todos []syncedTodo
+			if err := json.Unmarshal(r.Payload, &todos); err != nil {
+				return nil, 0, fmt.Errorf("failed to unmarshal response: %w", err)
+			}
+			return todos, 0, nil
 		}
 
-		if err := json.Unmarshal(r.Payload, &todos); err != nil {
-			log.Printf("❌ Failed to unmarshal response from %s: %v", r.From, err)
+		var page struct {
+			Todos       []syncedTodo `json:"todos"`
+			NextAfterID int          `json:"next_after_id"`
+		}
+		if err := json.Unmarshal(r.Payload, &page); err != nil {
+			return nil, 0, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		// A cursor that doesn't advance would page forever
+		if page.NextAfterID != 0 && page.NextAfterID <= afterID {
+			return nil, 0, fmt.Errorf("page after id %d did not advance", afterID)
+		}
+		return page.Todos, page.NextAfterID, nil
+	}
+
+	return nil, 0, fmt.Errorf("no response within %v", fullStateQueryTimeout)
+}
+
+// applySyncedTodos creates or reconciles received todos, adding to result.
+// Todos in seenExternIDs are skipped and applied todos are added to it.
+func (c *Cluster) applySyncedTodos(from string, todos []syncedTodo, seenExternIDs map[string]bool, result *SyncResult) {
+	for _, todo := range todos {
+		// Responses from nodes without namespaces hold only default todos
+		if todo.Namespace == "" {
+			todo.Namespace = models.DefaultNamespace
+		}
+
+		if err := validateTodoFields(todo.ExternID, todo.Todo, todo.Metadata); err != nil {
+			log.Printf("❌ Skipping invalid todo from %s: %v", from, err)
 			continue
 		}
 
-		log.Printf("📦 Received %d todos from %s", len(todos), r.From)
+		// Skip duplicates and todos outside of this node's shards
+		key := todoKey(todo.Namespace, todo.ExternID)
+		if seenExternIDs[key] || !c.ownsTodo(todo.ExternID) {
+			continue
+		}
 
-		for _, todo := range todos {
-			// Skip duplicates
-			if seenExternIDs[todo.ExternID] {
-				continue
-			}
+		// Don't let the received copy overwrite a newer local change that
+		// has not propagated yet
+		if !c.acceptSynced(key, todo.SyncVersion) {
+			log.Printf("⏭️  Todo %s from %s is older than the latest change seen here, skipping", todo.ExternID, from)
+			continue
+		}
 
-			// Check if todo already exists
-			existing, err := c.db.GetTodoByExternID(todo.ExternID)
-			if err != nil {
-				log.Printf("❌ Failed to check todo %s: %v", todo.ExternID, err)
-				continue
-			}
+		// Check if todo already exists
+		existing, err := c.db.GetTodoByExternID(todo.Namespace, todo.ExternID)
+		if err != nil {
+			log.Printf("❌ Failed to check todo %s: %v", todo.ExternID, err)
+			continue
+		}
 
-			if existing != nil {
-				// Already exists, skip
-				seenExternIDs[todo.ExternID] = true
-				continue
-			}
+		if existing != nil && existing.Todo == todo.Todo && existing.Completed == todo.Completed && sameMetadata(existing.Metadata, todo.Metadata) {
+			// Already up to date, skip
+			seenExternIDs[key] = true
+			continue
+		}
 
-			// Create todo in local database
-			_, err = c.db.CreateTodo(todo.ExternID, todo.Todo)
-			if err != nil {
-				log.Printf("❌ Failed to sync todo %s: %v", todo.ExternID, err)
-				continue
-			}
+		// Create or reconcile todo in local database
+		_, err = c.db.UpsertTodo(todo.Namespace, todo.ExternID, todo.Todo, todo.Origin, todo.Completed, todo.Metadata, todo.ExpiresAt)
+		if err != nil {
+			log.Printf("❌ Failed to sync todo %s: %v", todo.ExternID, err)
+			continue
+		}
 
-			seenExternIDs[todo.ExternID] = true
-			totalSynced++
+		seenExternIDs[key] = true
+		if existing != nil {
+			result.Reconciled++
+		} else {
+			result.Synced++
 		}
 	}
+}
 
-	log.Printf("✅ Full sync complete: %d todos synced", totalSynced)
+// acceptSynced decides whether a todo received in a full sync may be
+// applied, by the same rules as a repair. A copy without a version is only
+// accepted if no change to the todo was seen here either, since it cannot
+// be ordered against one.
+func (c *Cluster) acceptSynced(key string, v *eventVersion) bool {
+	if v == nil {
+		_, known := c.knownVersion(key)
+		return !known
+	}
+	c.clock.Witness(v.Lamport)
+	return c.acceptRepair(key, *v)
 }
@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestAcquireSyncSlotCapsConcurrentSyncs(t *testing.T) {
+	const limit = 2
+	c := &Cluster{
+		shutdown:  make(chan struct{}),
+		syncSlots: make(chan struct{}, limit),
+	}
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !c.acquireSyncSlot(time.Now().Add(5 * time.Second)) {
+				t.Error("slot not acquired before the deadline")
+				return
+			}
+			defer c.releaseSyncSlot()
+
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != limit {
+		t.Errorf("max concurrent syncs = %d, want %d", got, limit)
+	}
+}
+
+func TestAcquireSyncSlotGivesUpAtDeadline(t *testing.T) {
+	c := &Cluster{
+		shutdown:  make(chan struct{}),
+		syncSlots: make(chan struct{}, 1),
+	}
+
+	if !c.acquireSyncSlot(time.Now().Add(time.Second)) {
+		t.Fatal("first slot not acquired")
+	}
+	if c.acquireSyncSlot(time.Now().Add(20 * time.Millisecond)) {
+		t.Fatal("acquired a slot beyond the limit")
+	}
+
+	c.releaseSyncSlot()
+	if !c.acquireSyncSlot(time.Now().Add(time.Second)) {
+		t.Fatal("slot not acquired after release")
+	}
+}
+
+func TestApplySyncedTodosSkipsOlderCopies(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+
+	// A local change the peer has not seen yet
+	if _, err := db.CreateTodo(models.DefaultNamespace, "changed", "local", "node-a", nil, nil); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	c.recordVersion(todoKey(models.DefaultNamespace, "changed"), eventVersion{Lamport: 10, Timestamp: 100, NodeID: "node-a"})
+
+	synced := func(externID, text string, v *eventVersion) syncedTodo {
+		return syncedTodo{Namespace: models.DefaultNamespace, ExternID: externID, Todo: text, SyncVersion: v}
+	}
+	tests := []struct {
+		name string
+		todo syncedTodo
+		want string
+	}{
+		{"older copy", synced("changed", "remote", &eventVersion{Lamport: 9, Timestamp: 200, NodeID: "node-b"}), "local"},
+		{"unversioned copy", synced("changed", "remote", nil), "local"},
+		{"newer copy", synced("changed", "newer", &eventVersion{Lamport: 11, Timestamp: 100, NodeID: "node-b"}), "newer"},
+		{"unknown todo", synced("new", "remote", nil), "remote"},
+	}
+	for _, tt := range tests {
+		var result SyncResult
+		c.applySyncedTodos("node-b", []syncedTodo{tt.todo}, make(map[string]bool), &result)
+
+		todo, err := db.GetTodoByExternID(models.DefaultNamespace, tt.todo.ExternID)
+		if err != nil {
+			t.Fatalf("%s: GetTodoByExternID: %v", tt.name, err)
+		}
+		if todo == nil || todo.Todo != tt.want {
+			t.Errorf("%s: todo = %+v, want text %q", tt.name, todo, tt.want)
+		}
+	}
+
+	// Accepted versions advance the local clock
+	if got := c.clock.Time(); got <= 11 {
+		t.Errorf("clock = %d after a synced version at 11, want > 11", got)
+	}
+}
+
+func TestFullStatePagesCoverAllTodos(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	c.opts.QueryResponseSizeLimit = 1024
+
+	const total = 25
+	for i := 0; i < total; i++ {
+		if _, err := db.CreateTodo(models.DefaultNamespace, fmt.Sprintf("todo-%d", i), "Buy milk", "node-a", nil, nil); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+	// Versions travel with the todos and count towards the page size
+	c.recordVersion(todoKey(models.DefaultNamespace, "todo-3"), eventVersion{Lamport: 7, Timestamp: 100, NodeID: "node-b"})
+
+	seen := make(map[string]bool)
+	afterID, pages := 0, 0
+	for {
+		page, err := c.fullStatePage(afterID)
+		if err != nil {
+			t.Fatalf("fullStatePage(%d): %v", afterID, err)
+		}
+		pages++
+
+		data, err := json.Marshal(page)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if limit := c.opts.QueryResponseSizeLimit - queryResponseOverhead - len(c.nodeID); len(data) > limit {
+			t.Errorf("page after id %d is %d bytes, want at most %d", afterID, len(data), limit)
+		}
+
+		for _, raw := range page.Todos {
+			var todo syncedTodo
+			if err := json.Unmarshal(raw, &todo); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if seen[todo.ExternID] {
+				t.Errorf("todo %s sent twice", todo.ExternID)
+			}
+			seen[todo.ExternID] = true
+			if todo.ExternID == "todo-3" && (todo.SyncVersion == nil || todo.SyncVersion.Lamport != 7) {
+				t.Errorf("todo-3 sync version = %+v, want lamport 7", todo.SyncVersion)
+			}
+		}
+
+		if page.NextAfterID == 0 {
+			break
+		}
+		if page.NextAfterID <= afterID {
+			t.Fatalf("cursor did not advance past %d", afterID)
+		}
+		afterID = page.NextAfterID
+	}
+
+	if len(seen) != total {
+		t.Errorf("received %d todos, want %d", len(seen), total)
+	}
+	if pages < 2 {
+		t.Errorf("received %d page(s), want the todos split across several", pages)
+	}
+}
+
+func TestFullStatePageFitsEntryOfExactlyTheBudget(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	budget := c.pageBudget()
+
+	todo, err := db.CreateTodo(models.DefaultNamespace, "todo-1", "x", "node-a", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	// Pad the text until the entry takes exactly the page budget; the
+	// stored timestamps vary in length, so measure after every update
+	for i := 0; ; i++ {
+		data, err := json.Marshal(fullStateTodo{Todo: todo})
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if len(data) == budget {
+			break
+		}
+		if i == 10 {
+			t.Fatalf("entry is %d bytes, could not pad it to %d", len(data), budget)
+		}
+		var text string
+		if len(data) < budget {
+			text = todo.Todo + strings.Repeat("x", budget-len(data))
+		} else {
+			text = todo.Todo[:len(todo.Todo)-(len(data)-budget)]
+		}
+		if todo, err = db.UpdateTodo(todo.ID, &text, nil, nil, nil); err != nil {
+			t.Fatalf("UpdateTodo: %v", err)
+		}
+	}
+	if _, err := db.CreateTodo(models.DefaultNamespace, "todo-2", "Buy milk", "node-a", nil, nil); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	page, err := c.fullStatePage(0)
+	if err != nil {
+		t.Fatalf("fullStatePage: %v", err)
+	}
+	if len(page.Todos) != 1 || page.NextAfterID != todo.ID {
+		t.Fatalf("first page has %d todos and continues after %d, want todo %d alone", len(page.Todos), page.NextAfterID, todo.ID)
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if limit := c.opts.QueryResponseSizeLimit - queryResponseOverhead - len(c.nodeID); len(data) > limit {
+		t.Errorf("page is %d bytes, want at most %d", len(data), limit)
+	}
+
+	page, err = c.fullStatePage(page.NextAfterID)
+	if err != nil {
+		t.Fatalf("fullStatePage: %v", err)
+	}
+	if len(page.Todos) != 1 || page.NextAfterID != 0 {
+		t.Errorf("second page has %d todos and continues after %d, want todo-2 alone", len(page.Todos), page.NextAfterID)
+	}
+}
@@ -0,0 +1,266 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/metrics"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/hashicorp/serf/serf"
+)
+
+// repairLoop periodically compares a sample of local todos with the other
+// nodes until shutdown, healing drift caused by dropped sync events
+func (c *Cluster) repairLoop() {
+	ticker := time.NewTicker(c.opts.RepairInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.shutdown:
+			return
+		case <-ticker.C:
+			c.repairSample()
+		}
+	}
+}
+
+// repairSample checks a random sample of local todos on all alive peers
+func (c *Cluster) repairSample() {
+	if c.left.Load() || !c.IsReady() {
+		return
+	}
+
+	peers := c.alivePeers()
+	if len(peers) == 0 {
+		return
+	}
+
+	todos, err := c.db.SampleTodos(c.opts.RepairSampleSize)
+	if err != nil {
+		log.Printf("❌ Failed to sample todos for repair: %v", err)
+		return
+	}
+
+	for i := range todos {
+		c.repairTodo(&todos[i], peers)
+	}
+}
+
+// repairTodo pushes the local copy of a todo to peers that are missing it
+// or hold an older, diverging copy. A peer with a newer copy is left alone;
+// its own repairer pushes that copy here.
+func (c *Cluster) repairTodo(todo *models.Todo, peers []string) {
+	// Without the version of the latest change seen here, e.g. after a
+	// restart, the local copy cannot be ordered against the peers' copies
+	version, ok := c.knownVersion(todoKey(todo.Namespace, todo.ExternID))
+	if !ok {
+		return
+	}
+
+	acked, copies, err := c.collectTodoStates(todo.Namespace, todo.ExternID, peers)
+	if err != nil {
+		log.Printf("❌ Failed to check todo %s for repair: %v", todo.ExternID, err)
+		return
+	}
+
+	for _, peer := range peers {
+		// Without an ack the peer's state is unknown
+		if !acked[peer] {
+			continue
+		}
+
+		reason := "missing"
+		if remote, ok := copies[peer]; ok {
+			if remote.Todo == todo.Todo && remote.Completed == todo.Completed && maps.Equal(remote.Metadata, todo.Metadata) {
+				continue
+			}
+			if !newerThanCopy(version, todo, remote) {
+				continue
+			}
+			reason = "diverged"
+		} else if c.opts.TotalShards > 0 {
+			// The peer may just not own the todo's shard
+			continue
+		}
+
+		if err := c.pushRepair(todo, version, peer); err != nil {
+			log.Printf("❌ Failed to repair todo %s on %s: %v", todo.ExternID, peer, err)
+			continue
+		}
+		log.Printf("🩹 Pushed todo %s to %s (%s)", todo.ExternID, peer, reason)
+		metrics.RepairsPushed.WithLabelValues(reason).Inc()
+	}
+}
+
+// newerThanCopy reports whether the local copy of a todo, last changed at
+// version, is newer than a peer's copy. The todo versions count changes per
+// node and cannot be compared across nodes, so this compares the versions
+// of the latest changes, or when the peer has seen none since its start,
+// when both copies were last changed.
+func newerThanCopy(version eventVersion, todo *models.Todo, remote *syncedTodo) bool {
+	if remote.SyncVersion != nil {
+		return version.newerThan(*remote.SyncVersion)
+	}
+	return newerUpdate(todo.UpdatedAt, remote.UpdatedAt)
+}
+
+// newerUpdate reports whether a copy last changed at updatedAt is newer
+// than one last changed at otherUpdatedAt. Unknown times are never newer.
+func newerUpdate(updatedAt, otherUpdatedAt *time.Time) bool {
+	return updatedAt != nil && otherUpdatedAt != nil && updatedAt.After(*otherUpdatedAt)
+}
+
+// collectTodoStates asks the given peers for their copy of a todo. Returns
+// the peers that acknowledged the query and the copies of those that have
+// the todo.
+func (c *Cluster) collectTodoStates(namespace, externID string, peers []string) (map[string]bool, map[string]*syncedTodo, error) {
+	payload, err := json.Marshal(TodoStateRequest{Namespace: namespace, ExternID: externID})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal todo state query: %w", err)
+	}
+
+	resp, err := c.serf.Query(QueryTodoState, payload, &serf.QueryParam{
+		FilterNodes: peers,
+		RequestAck:  true,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send todo state query: %w", err)
+	}
+	defer resp.Close()
+
+	acked := make(map[string]bool)
+	copies := make(map[string]*syncedTodo)
+	ackCh, respCh := resp.AckCh(), resp.ResponseCh()
+
+	// Peers without the todo never respond, so only stop early once all did
+	for (ackCh != nil || respCh != nil) && len(copies) < len(peers) {
+		select {
+		case from, ok := <-ackCh:
+			if !ok {
+				ackCh = nil
+				continue
+			}
+			acked[from] = true
+		case r, ok := <-respCh:
+			if !ok {
+				respCh = nil
+				continue
+			}
+			var todo syncedTodo
+			if err := json.Unmarshal(r.Payload, &todo); err != nil {
+				log.Printf("❌ Failed to unmarshal todo state from %s: %v", r.From, err)
+				continue
+			}
+			acked[r.From] = true
+			copies[r.From] = &todo
+		}
+	}
+
+	return acked, copies, nil
+}
+
+// pushRepair sends the local copy of a todo to a single peer. It carries
+// the version of the latest change seen here, so the peer can reject the
+// repair if it already applied a newer change.
+func (c *Cluster) pushRepair(todo *models.Todo, version eventVersion, peer string) error {
+	event := TodoSyncEvent{
+		Type:      "repair",
+		Namespace: todo.Namespace,
+		ExternID:  todo.ExternID,
+		Todo:      todo.Todo,
+		Completed: &todo.Completed,
+		ExpiresAt: todo.ExpiresAt,
+		Origin:    todo.OriginNode,
+		Metadata:  todo.Metadata,
+		NodeID:    version.NodeID,
+		Timestamp: version.Timestamp,
+		Lamport:   version.Lamport,
+		UpdatedAt: todo.UpdatedAt,
+	}
+
+	payload, err := c.encodeSyncEvent(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal repair: %w", err)
+	}
+
+	resp, err := c.serf.Query(QueryRepair, payload, &serf.QueryParam{FilterNodes: []string{peer}})
+	if err != nil {
+		return fmt.Errorf("failed to send repair: %w", err)
+	}
+	resp.Close()
+	return nil
+}
+
+// handleRepairQuery applies a todo pushed by another node's repairer
+func (c *Cluster) handleRepairQuery(query *serf.Query) {
+	event, err := decodeSyncEvent(query.Payload)
+	if err != nil {
+		log.Printf("❌ Failed to unmarshal repair from %s: %v", query.SourceNode(), err)
+		return
+	}
+	if err := validateSyncEvent(event); err != nil {
+		log.Printf("❌ Dropping invalid repair from %s: %v", query.SourceNode(), err)
+		return
+	}
+	if !c.ownsTodo(event.ExternID) {
+		return
+	}
+
+	applied, err := c.applyRepair(event)
+	if err != nil {
+		log.Printf("❌ Failed to apply repair of todo %s: %v", event.ExternID, err)
+		return
+	}
+	if !applied {
+		log.Printf("⏭️  Repair of todo %s from %s is stale, skipping", event.ExternID, query.SourceNode())
+		return
+	}
+	log.Printf("🩹 Todo %s repaired by %s", event.ExternID, query.SourceNode())
+}
+
+// applyRepair stores a repaired todo unless the local copy is newer.
+// Returns whether the repair was applied.
+func (c *Cluster) applyRepair(event TodoSyncEvent) (bool, error) {
+	// Repairs are only pushed with a version, see repairTodo
+	if event.Lamport == 0 {
+		return false, nil
+	}
+
+	key := todoKey(event.Namespace, event.ExternID)
+	if _, ok := c.knownVersion(key); !ok {
+		// No change to the todo was seen here since the start, so fall
+		// back to when both copies were last changed
+		existing, err := c.db.GetTodoByExternID(event.Namespace, event.ExternID)
+		if err != nil {
+			return false, err
+		}
+		if existing != nil && !newerUpdate(event.UpdatedAt, existing.UpdatedAt) {
+			return false, nil
+		}
+	}
+
+	if !c.acceptRepair(key, versionOf(event)) {
+		return false, nil
+	}
+	c.forgetFetched(key)
+
+	if _, err := c.db.UpsertTodo(event.Namespace, event.ExternID, event.Todo, event.Origin, eventCompleted(event), event.Metadata, event.ExpiresAt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// alivePeers returns the names of all alive members except this node
+func (c *Cluster) alivePeers() []string {
+	var peers []string
+	for _, member := range c.serf.Members() {
+		if member.Status == serf.StatusAlive && member.Name != c.nodeID {
+			peers = append(peers, member.Name)
+		}
+	}
+	return peers
+}
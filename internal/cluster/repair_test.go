@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// waitForTodo polls until the todo has the given text or the deadline passes
+func waitForTodo(t *testing.T, db *database.DB, externID, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		todo, err := db.GetTodoByExternID(models.DefaultNamespace, externID)
+		if err != nil {
+			t.Fatalf("GetTodoByExternID: %v", err)
+		}
+		if todo != nil && todo.Todo == text {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("todo %s = %+v, want text %q", externID, todo, text)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRepairKeepsNewerCopy(t *testing.T) {
+	a, dbA := startTestNode(t, "node-a")
+	b, dbB := startTestNode(t, "node-b", addrOf(a))
+	key := todoKey(models.DefaultNamespace, "todo-1")
+
+	// node-a missed the latest change, made on node-b after many changes
+	// on node-a, so node-a's copy carries the higher per-node version
+	stale, err := dbA.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-a", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	text := "Buy milk"
+	for i := 0; i < 5; i++ {
+		if stale, err = dbA.UpdateTodo(stale.ID, &text, nil, nil, nil); err != nil {
+			t.Fatalf("UpdateTodo: %v", err)
+		}
+	}
+	a.recordVersion(key, eventVersion{Lamport: 3, Timestamp: 100, NodeID: "node-a"})
+	time.Sleep(10 * time.Millisecond)
+	newer, err := dbB.CreateTodo(models.DefaultNamespace, "todo-1", "Buy oat milk", "node-a", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	b.recordVersion(key, eventVersion{Lamport: 5, Timestamp: 100, NodeID: "node-b"})
+	if stale.Version <= newer.Version {
+		t.Fatalf("stale version %d, want above %d", stale.Version, newer.Version)
+	}
+
+	// The stale copy must not be pushed; the newer one repairs node-a. The
+	// repair queries of a node are handled in order, so once node-a is
+	// repaired, a push from node-a would have reached node-b already.
+	a.repairTodo(stale, a.alivePeers())
+	b.repairTodo(newer, b.alivePeers())
+	waitForTodo(t, dbA, "todo-1", "Buy oat milk")
+	waitForTodo(t, dbB, "todo-1", "Buy oat milk")
+
+	// After a restart node-b has no versions, and falls back to comparing
+	// when both copies were last changed
+	b.versionsMu.Lock()
+	delete(b.versions, key)
+	b.versionsMu.Unlock()
+	if _, err := dbB.UpsertTodo(models.DefaultNamespace, "todo-1", "Buy soy milk", "", false, nil, nil); err != nil {
+		t.Fatalf("UpsertTodo: %v", err)
+	}
+	if err := a.pushRepair(stale, eventVersion{Lamport: 7, Timestamp: 100, NodeID: "node-a"}, "node-b"); err != nil {
+		t.Fatalf("pushRepair: %v", err)
+	}
+	marker, err := dbA.CreateTodo(models.DefaultNamespace, "todo-2", "Buy bread", "node-a", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	if err := a.pushRepair(marker, eventVersion{Lamport: 8, Timestamp: 100, NodeID: "node-a"}, "node-b"); err != nil {
+		t.Fatalf("pushRepair: %v", err)
+	}
+	waitForTodo(t, dbB, "todo-2", "Buy bread")
+	waitForTodo(t, dbB, "todo-1", "Buy soy milk")
+}
+
+func TestRepairNeedsKnownVersion(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+
+	// A repair without a version, e.g. pushed right after a restart,
+	// could carry any old copy
+	applied, err := c.applyRepair(TodoSyncEvent{Type: "repair", Namespace: models.DefaultNamespace, ExternID: "todo-1", Todo: "Buy milk"})
+	if err != nil {
+		t.Fatalf("applyRepair: %v", err)
+	}
+	if applied {
+		t.Error("repair without a version was applied")
+	}
+}
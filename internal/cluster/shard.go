@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// shardOf returns the shard an extern_id hashes into
+func shardOf(externID string, totalShards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(externID))
+	return int(h.Sum32() % uint32(totalShards))
+}
+
+// validateShards checks that the owned shards are within the shard range
+func validateShards(totalShards int, ownedShards []int) error {
+	if totalShards < 0 {
+		return fmt.Errorf("total_shards must not be negative, got %d", totalShards)
+	}
+	if totalShards == 0 {
+		if len(ownedShards) > 0 {
+			return fmt.Errorf("owned_shards requires total_shards to be set")
+		}
+		return nil
+	}
+	if len(ownedShards) == 0 {
+		return fmt.Errorf("owned_shards must not be empty when total_shards is set")
+	}
+	for _, shard := range ownedShards {
+		if shard < 0 || shard >= totalShards {
+			return fmt.Errorf("owned shard %d out of range [0, %d)", shard, totalShards)
+		}
+	}
+	return nil
+}
+
+// ownsTodo returns true if this node stores the todo with the given extern_id.
+// Without sharding every node stores every todo.
+func (c *Cluster) ownsTodo(externID string) bool {
+	if c.opts.TotalShards == 0 {
+		return true
+	}
+	return c.ownedShards[shardOf(externID, c.opts.TotalShards)]
+}
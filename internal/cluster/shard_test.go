@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/database"
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// externIDInShard returns an extern_id hashing into the given shard
+func externIDInShard(t *testing.T, shard, totalShards int) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if externID := fmt.Sprintf("todo-%d", i); shardOf(externID, totalShards) == shard {
+			return externID
+		}
+	}
+	t.Fatalf("no extern_id found in shard %d", shard)
+	return ""
+}
+
+func TestNodeDropsOutOfShardTodos(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	c.opts.TotalShards = 2
+	c.ownedShards = map[int]bool{0: true}
+
+	owned := externIDInShard(t, 0, 2)
+	foreign := externIDInShard(t, 1, 2)
+
+	// Sync events
+	for _, externID := range []string{owned, foreign} {
+		for _, eventType := range []string{"created", "updated"} {
+			payload, err := json.Marshal(TodoSyncEvent{
+				Type:      eventType,
+				Namespace: models.DefaultNamespace,
+				ExternID:  externID,
+				Todo:      "Buy milk",
+				NodeID:    "node-b",
+				Timestamp: time.Now().Unix(),
+				Lamport:   c.tick(),
+			})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if eventType == "created" {
+				c.handleTodoCreated(payload)
+			} else {
+				c.handleTodoUpdated(payload)
+			}
+		}
+	}
+
+	// Full sync pages
+	var result SyncResult
+	c.applySyncedTodos("node-b", []syncedTodo{
+		{Namespace: "team-a", ExternID: owned, Todo: "Buy bread"},
+		{Namespace: models.DefaultNamespace, ExternID: foreign, Todo: "Buy bread"},
+	}, make(map[string]bool), &result)
+	if result.Synced != 1 {
+		t.Errorf("full sync stored %d todos, want 1", result.Synced)
+	}
+
+	for _, ns := range []string{models.DefaultNamespace, "team-a"} {
+		todos, err := db.ListTodosWithOptions(database.ListOptions{Namespace: ns})
+		if err != nil {
+			t.Fatalf("ListTodosWithOptions: %v", err)
+		}
+		if len(todos) != 1 || todos[0].ExternID != owned {
+			t.Errorf("todos in %s = %+v, want only %s", ns, todos, owned)
+		}
+	}
+}
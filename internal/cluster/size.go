@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// userEventOverhead is room left in a user event for Serf's message header
+// around the event name and payload
+const userEventOverhead = 48
+
+// ErrTodoTooLarge is returned by CheckTodoSize for todos that cannot be
+// synchronized because they exceed the Serf payload limits
+var ErrTodoTooLarge = errors.New("todo is too large to synchronize")
+
+// CheckTodoSize returns ErrTodoTooLarge if a todo with the given content
+// would not fit a sync event or a full state page. Its id, version and
+// timestamps are assumed at their largest, so the check holds however the
+// todo is stored and changed later.
+func (c *Cluster) CheckTodoSize(todo *models.Todo) error {
+	now := time.Now()
+	worst := *todo
+	worst.ID, worst.Version = math.MaxInt, math.MaxInt
+	worst.CreatedAt = now
+	worst.CompletedAt = &now
+	worst.UpdatedAt = &now
+
+	event := c.todoEvent("updated", &worst)
+	event.Lamport = math.MaxUint64
+	payload, err := c.encodeSyncEvent(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if size := len(EventTodoUpdated) + len(payload) + userEventOverhead; size > c.opts.UserEventSizeLimit {
+		return fmt.Errorf("%w: its sync event takes %d bytes, the user event limit is %d", ErrTodoTooLarge, size, c.opts.UserEventSizeLimit)
+	}
+
+	entry, err := json.Marshal(fullStateTodo{
+		Todo:        &worst,
+		SyncVersion: &eventVersion{Lamport: math.MaxUint64, Timestamp: now.Unix(), NodeID: c.nodeID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal todo: %w", err)
+	}
+	if budget := c.pageBudget(); len(entry) > budget {
+		return fmt.Errorf("%w: it takes %d bytes in a full sync, the query response limit leaves %d", ErrTodoTooLarge, len(entry), budget)
+	}
+	return nil
+}
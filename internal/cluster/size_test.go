@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestCheckTodoSize(t *testing.T) {
+	c := newTestCluster(t, nil)
+	c.opts.UserEventSizeLimit = 512
+	c.opts.QueryResponseSizeLimit = 1024
+
+	small := &models.Todo{Namespace: "default", ExternID: "todo-1", Todo: "Buy milk", OriginNode: "node-a"}
+	if err := c.CheckTodoSize(small); err != nil {
+		t.Errorf("small todo rejected: %v", err)
+	}
+
+	long := *small
+	long.Todo = strings.Repeat("x", 500)
+	if err := c.CheckTodoSize(&long); !errors.Is(err, ErrTodoTooLarge) {
+		t.Errorf("todo exceeding the user event limit: err = %v, want ErrTodoTooLarge", err)
+	}
+
+	// Fits a larger user event, but not a full sync page
+	c.opts.UserEventSizeLimit = 9216
+	labeled := *small
+	labeled.Metadata = models.Metadata{}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		labeled.Metadata[key] = strings.Repeat("v", 256)
+	}
+	if err := c.CheckTodoSize(&labeled); !errors.Is(err, ErrTodoTooLarge) {
+		t.Errorf("todo exceeding the full sync page budget: err = %v, want ErrTodoTooLarge", err)
+	}
+}
+
+func TestLargestAcceptedTodoFitsFullStatePage(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	c.opts.UserEventSizeLimit = 9216
+
+	// Grow the text until the full sync page budget rejects it
+	todo := &models.Todo{Namespace: models.DefaultNamespace, ExternID: "todo-1", OriginNode: "node-a"}
+	for {
+		todo.Todo += "x"
+		if err := c.CheckTodoSize(todo); err != nil {
+			todo.Todo = todo.Todo[1:]
+			break
+		}
+	}
+
+	stored, err := db.CreateTodo(todo.Namespace, todo.ExternID, todo.Todo, todo.OriginNode, nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	completed := true
+	if _, err := db.UpdateTodo(stored.ID, nil, &completed, nil, nil); err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+	c.recordVersion(todoKey(todo.Namespace, todo.ExternID), eventVersion{Lamport: 1 << 62, Timestamp: 100, NodeID: "node-a"})
+
+	page, err := c.fullStatePage(0)
+	if err != nil {
+		t.Fatalf("fullStatePage: %v", err)
+	}
+	if len(page.Todos) != 1 {
+		t.Errorf("todo with %d bytes of text accepted by CheckTodoSize is not sent in full sync", len(todo.Todo))
+	}
+}
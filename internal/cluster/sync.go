@@ -1,66 +1,121 @@
 package cluster
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/metrics"
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
 )
 
 // BroadcastTodoCreated broadcasts a todo created event to the cluster
 func (c *Cluster) BroadcastTodoCreated(todo *models.Todo) error {
-	event := TodoSyncEvent{
-		Type:      "created",
-		ExternID:  todo.ExternID,
-		Todo:      todo.Todo,
-		Completed: &todo.Completed,
-		NodeID:    c.nodeID,
-		Timestamp: time.Now().Unix(),
-	}
-
-	return c.broadcastEvent(EventTodoCreated, event)
+	return c.broadcastEvent(EventTodoCreated, c.todoEvent("created", todo), false)
 }
 
-// BroadcastTodoUpdated broadcasts a todo updated event to the cluster
+// BroadcastTodoUpdated broadcasts a todo updated event to the cluster.
+// Updates carry the full state of the todo, so with UpdateCoalescePeriod
+// set they are coalesced: only the latest update to a todo needs to arrive.
 func (c *Cluster) BroadcastTodoUpdated(todo *models.Todo) error {
-	event := TodoSyncEvent{
-		Type:      "updated",
+	event := c.todoEvent("updated", todo)
+	if c.opts.UpdateCoalescePeriod > 0 {
+		return c.broadcastEvent(coalescedUpdateName(todo.Namespace, todo.ExternID), event, true)
+	}
+	return c.broadcastEvent(EventTodoUpdated, event, false)
+}
+
+// todoEvent builds the sync event carrying the full state of a todo
+func (c *Cluster) todoEvent(eventType string, todo *models.Todo) TodoSyncEvent {
+	return TodoSyncEvent{
+		Type:      eventType,
+		Namespace: todo.Namespace,
 		ExternID:  todo.ExternID,
 		Todo:      todo.Todo,
 		Completed: &todo.Completed,
+		ExpiresAt: todo.ExpiresAt,
+		Origin:    todo.OriginNode,
+		Metadata:  todo.Metadata,
 		NodeID:    c.nodeID,
 		Timestamp: time.Now().Unix(),
 	}
-
-	return c.broadcastEvent(EventTodoUpdated, event)
 }
 
 // BroadcastTodoDeleted broadcasts a todo deleted event to the cluster
-func (c *Cluster) BroadcastTodoDeleted(externID string) error {
+func (c *Cluster) BroadcastTodoDeleted(namespace, externID string) error {
 	event := TodoSyncEvent{
 		Type:      "deleted",
+		Namespace: namespace,
 		ExternID:  externID,
 		NodeID:    c.nodeID,
 		Timestamp: time.Now().Unix(),
 	}
 
-	return c.broadcastEvent(EventTodoDeleted, event)
+	return c.broadcastEvent(EventTodoDeleted, event, false)
 }
 
-// broadcastEvent sends a user event to the cluster
-func (c *Cluster) broadcastEvent(eventName string, event TodoSyncEvent) error {
-	payload, err := json.Marshal(event)
+// ErrEventTooLarge is returned by the broadcast methods when a sync event
+// exceeds the user event size limit. The change is not synchronized.
+var ErrEventTooLarge = errors.New("sync event exceeds the user event size limit")
+
+// broadcastEvent sends a user event to the cluster. Events that fail to
+// send are queued in the outbox and retried (see outbox.go); events over
+// the size limit are rejected since they would fail on every attempt.
+//
+// Receivers with a coalesce period merge coalesced events of the same name
+// arriving within it, keeping only the latest and dropping the others. So
+// only events a later one with the same name supersedes may be coalesced;
+// created and deleted events never are.
+func (c *Cluster) broadcastEvent(eventName string, event TodoSyncEvent, coalesce bool) error {
+	// Nothing to broadcast to after leaving the cluster. After Stop the
+	// node has left too, but the event is queued for the next start.
+	stopped := c.stopped.Load()
+	if c.left.Load() && !stopped {
+		return fmt.Errorf("cannot broadcast %s: node has left the cluster", eventName)
+	}
+
+	// Stamp the event with logical time
+	event.Lamport = c.tick()
+	key := todoKey(event.Namespace, event.ExternID)
+
+	payload, err := c.encodeSyncEvent(event)
 	if err != nil {
 		return fmt.Errorf("failed to marshal event: %w", err)
 	}
 
-	err = c.serf.UserEvent(eventName, payload, false)
+	// Serf checks the limit before and after adding its message header
+	if size := len(eventName) + len(payload) + userEventOverhead; size > c.opts.UserEventSizeLimit {
+		return fmt.Errorf("%w: %s for %s takes %d bytes, the limit is %d", ErrEventTooLarge, eventName, event.ExternID, size, c.opts.UserEventSizeLimit)
+	}
+
+	err = errStopped
+	if !stopped {
+		err = c.userEvent(eventName, payload, coalesce)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to broadcast event: %w", err)
+		if qerr := c.queueBroadcast(key, eventName, payload, coalesce); qerr != nil {
+			return fmt.Errorf("failed to broadcast %s for %s: %v (%w)", eventName, event.ExternID, err, qerr)
+		}
+		// The queued event still reaches the peers, so it is the latest
+		// change and must not be overwritten by older events meanwhile
+		c.recordBroadcast(eventName, event)
+		return fmt.Errorf("failed to broadcast %s for %s, queued for retry: %w", eventName, event.ExternID, err)
 	}
 
+	c.dequeueBroadcast(key, nil)
+	c.recordBroadcast(eventName, event)
+	metrics.SyncBytesBroadcast.WithLabelValues(eventKind(eventName)).Add(float64(len(payload)))
+
 	log.Printf("📤 Broadcasted %s: %s", eventName, event.ExternID)
 	return nil
 }
+
+// recordBroadcast remembers a broadcast event as the latest change to its
+// todo, once it was sent or queued for sending
+func (c *Cluster) recordBroadcast(eventName string, event TodoSyncEvent) {
+	key := todoKey(event.Namespace, event.ExternID)
+	if c.recordVersion(key, versionOf(event)) && eventName == EventTodoDeleted {
+		c.markDeleted(key)
+	}
+}
@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+	"github.com/hashicorp/serf/serf"
+)
+
+func TestBroadcastCoalesceFlag(t *testing.T) {
+	tests := []struct {
+		name   string
+		period time.Duration
+		want   []sentEvent
+	}{
+		{
+			name: "disabled",
+			want: []sentEvent{
+				{name: EventTodoCreated},
+				{name: EventTodoUpdated},
+				{name: EventTodoDeleted},
+			},
+		},
+		{
+			// Only updates, which later updates supersede, are coalesced
+			name:   "updates coalesced",
+			period: time.Second,
+			want: []sentEvent{
+				{name: EventTodoCreated},
+				{name: "todo:updated:default/todo-1", coalesce: true},
+				{name: EventTodoDeleted},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+			defer db.Close()
+			c := newTestCluster(t, db)
+			c.opts.UpdateCoalescePeriod = tt.period
+			fail := false
+			sent := stubUserEvent(c, &fail)
+
+			todo := &models.Todo{Namespace: models.DefaultNamespace, ExternID: "todo-1", Todo: "Buy milk"}
+			if err := c.BroadcastTodoCreated(todo); err != nil {
+				t.Fatalf("BroadcastTodoCreated: %v", err)
+			}
+			if err := c.BroadcastTodoUpdated(todo); err != nil {
+				t.Fatalf("BroadcastTodoUpdated: %v", err)
+			}
+			if err := c.BroadcastTodoDeleted(todo.Namespace, todo.ExternID); err != nil {
+				t.Fatalf("BroadcastTodoDeleted: %v", err)
+			}
+
+			if len(*sent) != len(tt.want) {
+				t.Fatalf("sent %d events, want %d", len(*sent), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got := (*sent)[i]; got.name != want.name || got.coalesce != want.coalesce {
+					t.Errorf("event %d = %s (coalesce %v), want %s (coalesce %v)", i, got.name, got.coalesce, want.name, want.coalesce)
+				}
+			}
+		})
+	}
+}
+
+func TestQueuedBroadcastKeepsCoalesceFlag(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	c := newTestCluster(t, db)
+	c.opts.UpdateCoalescePeriod = time.Second
+	fail := true
+	sent := stubUserEvent(c, &fail)
+
+	todo := &models.Todo{Namespace: models.DefaultNamespace, ExternID: "todo-1", Todo: "Buy milk"}
+	if err := c.BroadcastTodoUpdated(todo); err == nil {
+		t.Fatal("BroadcastTodoUpdated succeeded although the send failed")
+	}
+
+	fail = false
+	c.retryDueBroadcasts(time.Now().Add(outboxMinBackoff))
+	if len(*sent) != 1 || (*sent)[0].name != "todo:updated:default/todo-1" || !(*sent)[0].coalesce {
+		t.Fatalf("retried %+v, want the coalesced update", *sent)
+	}
+}
+
+func TestCoalescedUpdateIsApplied(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "todos.db"))
+	defer db.Close()
+	senderDB := openTestDB(t, filepath.Join(t.TempDir(), "sender.db"))
+	defer senderDB.Close()
+	sender := newTestCluster(t, senderDB)
+	sender.nodeID = "node-b"
+	sender.opts.UpdateCoalescePeriod = time.Second
+	fail := false
+	sent := stubUserEvent(sender, &fail)
+	receiver := newTestCluster(t, db)
+
+	if _, err := db.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-b", nil, nil); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	todo := &models.Todo{Namespace: models.DefaultNamespace, ExternID: "todo-1", Todo: "Buy oat milk", OriginNode: "node-b"}
+	if err := sender.BroadcastTodoUpdated(todo); err != nil {
+		t.Fatalf("BroadcastTodoUpdated: %v", err)
+	}
+
+	event := (*sent)[0]
+	receiver.processEvent(serf.UserEvent{Name: event.name, Payload: event.payload, Coalesce: event.coalesce})
+
+	got, err := db.GetTodoByExternID(models.DefaultNamespace, "todo-1")
+	if err != nil {
+		t.Fatalf("GetTodoByExternID: %v", err)
+	}
+	if got.Todo != "Buy oat milk" {
+		t.Errorf("todo after a coalesced update = %q, want %q", got.Todo, "Buy oat milk")
+	}
+}
@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/hashicorp/serf/coordinate"
+)
+
+// Topology is the cluster as a graph: the members as nodes and the
+// estimated round-trip times between them as weighted edges
+type Topology struct {
+	Local string         `json:"local" doc:"Name of the node that computed the topology"`
+	Nodes []TopologyNode `json:"nodes" doc:"Cluster members"`
+	Edges []TopologyEdge `json:"edges" doc:"Estimated latencies between members with known network coordinates"`
+}
+
+// TopologyNode is a cluster member in the topology graph
+type TopologyNode struct {
+	Name   string `json:"name" doc:"Node name"`
+	Addr   string `json:"addr" doc:"Gossip address"`
+	Status string `json:"status" doc:"Member status (alive, leaving, left, failed)"`
+}
+
+// TopologyEdge is the estimated round-trip time between two members. The
+// graph is undirected, each pair of members is listed once.
+type TopologyEdge struct {
+	Source string  `json:"source" doc:"Name of one node"`
+	Target string  `json:"target" doc:"Name of the other node"`
+	RTTMs  float64 `json:"rtt_ms" doc:"Estimated round-trip time in milliseconds"`
+}
+
+// Topology returns the members and the latencies between every pair of
+// members, estimated from Serf's network coordinates. Members without a
+// known coordinate are listed without edges.
+func (c *Cluster) Topology() Topology {
+	members := c.serf.Members()
+	topology := Topology{
+		Local: c.nodeID,
+		Nodes: make([]TopologyNode, len(members)),
+		Edges: []TopologyEdge{},
+	}
+
+	coords := make([]*coordinate.Coordinate, len(members))
+	for i, member := range members {
+		topology.Nodes[i] = TopologyNode{
+			Name:   member.Name,
+			Addr:   member.Addr.String(),
+			Status: member.Status.String(),
+		}
+		coords[i] = c.coordinateOf(member.Name)
+	}
+
+	for i := range members {
+		for j := i + 1; j < len(members); j++ {
+			if coords[i] == nil || coords[j] == nil || !coords[i].IsCompatibleWith(coords[j]) {
+				continue
+			}
+			topology.Edges = append(topology.Edges, TopologyEdge{
+				Source: members[i].Name,
+				Target: members[j].Name,
+				RTTMs:  float64(coords[i].DistanceTo(coords[j])) / float64(time.Millisecond),
+			})
+		}
+	}
+
+	return topology
+}
+
+// coordinateOf returns the network coordinate of a member, or nil if it
+// is not known (yet)
+func (c *Cluster) coordinateOf(name string) *coordinate.Coordinate {
+	if name == c.nodeID {
+		local, err := c.serf.GetCoordinate()
+		if err != nil {
+			return nil
+		}
+		return local
+	}
+
+	coord, ok := c.serf.GetCachedCoordinate(name)
+	if !ok {
+		return nil
+	}
+	return coord
+}
@@ -1,5 +1,15 @@
 package cluster
 
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+
+	"github.com/hashicorp/serf/serf"
+)
+
 // Event types for todo synchronization
 const (
 	EventTodoCreated = "todo:created"
@@ -7,20 +17,64 @@ const (
 	EventTodoDeleted = "todo:deleted"
 )
 
+// coalescedUpdateName returns the event name of a coalescable update to a
+// todo. Serf coalesces user events by name, so the name carries the todo
+// to only merge updates to the same todo.
+func coalescedUpdateName(namespace, externID string) string {
+	return EventTodoUpdated + ":" + todoKey(namespace, externID)
+}
+
+// eventKind returns the event type of a user event name, stripping the
+// todo from coalescable update names
+func eventKind(name string) string {
+	if strings.HasPrefix(name, EventTodoUpdated+":") {
+		return EventTodoUpdated
+	}
+	return name
+}
+
 // Query types for cluster communication
 const (
 	QueryFullState = "sync:full-state"
 	QueryCount     = "sync:count"
+	QueryTodoState = "sync:todo-state"
+	QueryRepair    = "sync:repair"
+	QueryFreshness = "sync:freshness"
 )
 
 // TodoSyncEvent represents a todo synchronization event
 type TodoSyncEvent struct {
-	Type      string `json:"type"`       // "created", "updated", "deleted"
-	ExternID  string `json:"extern_id"`
-	Todo      string `json:"todo,omitempty"`
-	Completed *bool  `json:"completed,omitempty"`
-	NodeID    string `json:"node_id"`
-	Timestamp int64  `json:"timestamp"`
+	Type      string           `json:"type"`                // "created", "updated", "deleted"
+	Namespace string           `json:"namespace,omitempty"` // empty from nodes without namespaces
+	ExternID  string           `json:"extern_id"`
+	Todo      string           `json:"todo,omitempty"`
+	Completed *bool            `json:"completed,omitempty"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	Origin    string           `json:"origin_node,omitempty"` // node the todo was created on
+	Metadata  models.Metadata  `json:"metadata"`              // null (e.g. from older nodes) leaves it unchanged
+	NodeID    string           `json:"node_id"`
+	Timestamp int64            `json:"timestamp"`            // sender's wall-clock time, for logs and tie-breaking
+	Lamport   serf.LamportTime `json:"lamport"`              // logical time, skew-independent ordering
+	UpdatedAt *time.Time       `json:"updated_at,omitempty"` // sender's last change to the todo, repairs only
+}
+
+// FullStateRequest is the payload of a full state query. The todos are
+// paged by id, since a query response must fit the response size limit.
+type FullStateRequest struct {
+	AfterID int `json:"after_id"`
+}
+
+// FullStateResponse is one page of todos in response to a full state query
+type FullStateResponse struct {
+	Todos       []json.RawMessage `json:"todos"`
+	NextAfterID int               `json:"next_after_id,omitempty"` // 0 on the last page
+}
+
+// FreshnessResponse reports how recent a node's data is, so the freshest
+// node can be elected to serve a full sync
+type FreshnessResponse struct {
+	NodeID        string     `json:"node_id"`
+	LastUpdatedAt *time.Time `json:"last_updated_at,omitempty"` // nil if the node has no todos
 }
 
 // CountResponse represents a response to a count query
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -14,14 +17,22 @@ type Config struct {
 	Node     NodeConfig    `yaml:"node"`
 	Cluster  ClusterConfig `yaml:"cluster"`
 	LogLevel string        `yaml:"log_level,omitempty"` // debug, info, warn, error
+
+	ShutdownTimeout int `yaml:"shutdown_timeout,omitempty"` // seconds
+
+	// Reject API changes to completed todos and drop sync updates for them
+	ImmutableAfterCompletion bool `yaml:"immutable_after_completion,omitempty"`
+
+	TTL       TTLConfig       `yaml:"ttl,omitempty"`
+	Readiness ReadinessConfig `yaml:"readiness,omitempty"`
 }
 
 // NodeConfig contains node-specific configuration
 type NodeConfig struct {
-	Name     string      `yaml:"name"`
-	Serf     SerfConfig  `yaml:"serf"`
-	HTTP     HTTPConfig  `yaml:"http"`
-	Database DBConfig    `yaml:"database"`
+	Name     string     `yaml:"name"`
+	Serf     SerfConfig `yaml:"serf"`
+	HTTP     HTTPConfig `yaml:"http"`
+	Database DBConfig   `yaml:"database"`
 }
 
 // SerfConfig contains Serf-specific configuration
@@ -32,19 +43,102 @@ type SerfConfig struct {
 
 // HTTPConfig contains HTTP server configuration
 type HTTPConfig struct {
-	Port int `yaml:"port"`
+	Port       int    `yaml:"port"`
+	BindAddr   string `yaml:"bind_addr,omitempty"`   // host/IP to listen on, empty means all interfaces
+	AdminToken string `yaml:"admin_token,omitempty"` // bearer token for /admin endpoints, empty disables them
+
+	TLSCertFile string `yaml:"tls_cert_file,omitempty"` // serve HTTPS (with HTTP/2) if set together with tls_key_file
+	TLSKeyFile  string `yaml:"tls_key_file,omitempty"`
+	H2C         bool   `yaml:"h2c,omitempty"` // accept cleartext HTTP/2 (h2c) on plain HTTP
+
+	Compress        bool `yaml:"compress,omitempty"`          // gzip JSON responses for clients that accept it
+	CompressMinSize int  `yaml:"compress_min_size,omitempty"` // bytes, smaller responses are sent uncompressed
+
+	RequestTimeout int `yaml:"request_timeout,omitempty"` // seconds a request may take before 503, 0 disables
 }
 
 // DBConfig contains database configuration
 type DBConfig struct {
-	Path string `yaml:"path"`
+	Path        string `yaml:"path"`
+	CacheSize   int    `yaml:"cache_size,omitempty"`   // number of todos, 0 disables the cache
+	CacheTTL    int    `yaml:"cache_ttl,omitempty"`    // seconds, 0 means no expiry
+	DirMode     string `yaml:"dir_mode,omitempty"`     // octal, e.g. "0700"
+	FileMode    string `yaml:"file_mode,omitempty"`    // octal, e.g. "0600"
+	ReplicaPath string `yaml:"replica_path,omitempty"` // read-only replica used for reads, empty disables
+	BackupDir   string `yaml:"backup_dir,omitempty"`   // directory for online backups, defaults to the database directory
+
+	MemoryBudgetMB int `yaml:"memory_budget_mb,omitempty"` // sizes SQLite page cache and mmap, 0 keeps SQLite defaults
+
+	// What to do when the database was migrated by a newer binary: refuse
+	// (default) to start, or warn and start anyway
+	OnNewerSchema string `yaml:"on_newer_schema,omitempty"`
+
+	IncrementalVacuum bool `yaml:"incremental_vacuum,omitempty"` // incremental auto_vacuum, reclaim space via POST /admin/vacuum
+	VacuumInterval    int  `yaml:"vacuum_interval,omitempty"`    // seconds between incremental vacuums, 0 disables
+}
+
+// AllowNewerSchema returns true if a database migrated by a newer binary
+// is opened with a warning instead of refusing to start
+func (c DBConfig) AllowNewerSchema() bool {
+	return c.OnNewerSchema == "warn"
 }
 
 // ClusterConfig contains cluster configuration
 type ClusterConfig struct {
-	Seeds       []string `yaml:"seeds"`
-	EncryptKey  string   `yaml:"encrypt_key,omitempty"`
-	JoinTimeout int      `yaml:"join_timeout,omitempty"` // seconds
+	Seeds           []string `yaml:"seeds"`
+	EncryptKey      string   `yaml:"encrypt_key,omitempty"`
+	EncryptKeyFile  string   `yaml:"encrypt_key_file,omitempty"`  // file with the base64 key, e.g. a mounted secret
+	KeyringFile     string   `yaml:"keyring_file,omitempty"`      // where rotated keys are persisted, defaults to <database path>.keyring
+	JoinTimeout     int      `yaml:"join_timeout,omitempty"`      // seconds
+	LeaveTimeout    int      `yaml:"leave_timeout,omitempty"`     // seconds
+	JoinGracePeriod int      `yaml:"join_grace_period,omitempty"` // seconds to keep retrying unreachable seeds before starting alone, 0 disables
+	MaxSyncSilence  int      `yaml:"max_sync_silence,omitempty"`  // seconds without applied sync events before readiness fails, 0 disables
+	ProtocolVersion int      `yaml:"protocol_version,omitempty"`  // Serf protocol version, 0 keeps Serf's default
+	EventEncoding   string   `yaml:"event_encoding,omitempty"`    // sync event payloads: json (default) or msgpack
+
+	BroadcastRetryMaxAge int `yaml:"broadcast_retry_max_age,omitempty"` // seconds to retry failed broadcasts (default 300)
+	UpdateCoalescePeriod int `yaml:"update_coalesce_period,omitempty"`  // seconds within which updates to a todo are coalesced, 0 disables
+
+	MaxConcurrentSyncs int `yaml:"max_concurrent_syncs,omitempty"` // full state queries of other nodes answered at once (default 4)
+
+	Sharding      ShardingConfig      `yaml:"sharding,omitempty"`
+	Repair        RepairConfig        `yaml:"repair,omitempty"`
+	PayloadLimits PayloadLimitsConfig `yaml:"payload_limits,omitempty"`
+}
+
+// PayloadLimitsConfig contains Serf payload size limits in bytes. 0 keeps
+// Serf's default; the limits must be the same on all nodes.
+type PayloadLimitsConfig struct {
+	Query         int `yaml:"query,omitempty"`          // query payloads (default 1024)
+	QueryResponse int `yaml:"query_response,omitempty"` // query responses, sizes full sync pages (default 1024)
+	UserEvent     int `yaml:"user_event,omitempty"`     // sync events (default 512, at most 9216)
+}
+
+// ShardingConfig contains extern_id hash sharding configuration
+type ShardingConfig struct {
+	TotalShards int   `yaml:"total_shards,omitempty"`  // 0 disables sharding
+	OwnedShards []int `yaml:"owned_shards,omitempty"`  // shards stored by this node
+	FetchOnMiss bool  `yaml:"fetch_on_miss,omitempty"` // look up todos missing locally on other nodes
+}
+
+// RepairConfig contains background consistency repair configuration
+type RepairConfig struct {
+	Interval   int `yaml:"interval,omitempty"`    // seconds, 0 disables
+	SampleSize int `yaml:"sample_size,omitempty"` // todos checked per round
+}
+
+// TTLConfig contains todo expiration configuration
+type TTLConfig struct {
+	AfterCreation   int `yaml:"after_creation,omitempty"`   // seconds, 0 disables
+	AfterCompletion int `yaml:"after_completion,omitempty"` // seconds, 0 disables
+	SweepInterval   int `yaml:"sweep_interval,omitempty"`   // seconds
+}
+
+// ReadinessConfig contains criteria /health/ready checks in addition to
+// the initial sync having completed
+type ReadinessConfig struct {
+	MinMembers      int  `yaml:"min_members,omitempty"`       // alive members including this node, 0 disables
+	CheckDBWritable bool `yaml:"check_db_writable,omitempty"` // require the database to accept writes
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -64,19 +158,177 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Node.HTTP.Port == 0 {
 		config.Node.HTTP.Port = 8080
 	}
+	if config.Node.HTTP.CompressMinSize == 0 {
+		config.Node.HTTP.CompressMinSize = 1024
+	}
 	if config.Node.Database.Path == "" {
 		config.Node.Database.Path = "./todos.db"
 	}
 	if config.Cluster.JoinTimeout == 0 {
-		config.Cluster.JoinTimeout = 10
+		config.Cluster.JoinTimeout = 30
+	}
+	if config.Cluster.LeaveTimeout == 0 {
+		config.Cluster.LeaveTimeout = 5
 	}
 	if config.LogLevel == "" {
 		config.LogLevel = "info"
 	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 10
+	}
+	if config.TTL.SweepInterval == 0 {
+		config.TTL.SweepInterval = 60
+	}
+	if config.Cluster.MaxConcurrentSyncs == 0 {
+		config.Cluster.MaxConcurrentSyncs = 4
+	}
+	if config.Cluster.Repair.SampleSize == 0 {
+		config.Cluster.Repair.SampleSize = 10
+	}
+
+	if config.Cluster.Repair.Interval < 0 || config.Cluster.Repair.SampleSize < 0 {
+		return nil, fmt.Errorf("invalid cluster repair config: interval and sample_size must not be negative")
+	}
+
+	limits := config.Cluster.PayloadLimits
+	if limits.Query < 0 || limits.QueryResponse < 0 || limits.UserEvent < 0 {
+		return nil, fmt.Errorf("invalid cluster payload_limits: limits must not be negative")
+	}
+	if limits.QueryResponse > 0 && limits.QueryResponse < 512 {
+		return nil, fmt.Errorf("invalid cluster payload_limits: query_response %d is too small to hold a todo (minimum 512)", limits.QueryResponse)
+	}
+	if limits.UserEvent > 9216 {
+		return nil, fmt.Errorf("invalid cluster payload_limits: user_event %d exceeds Serf's maximum of 9216", limits.UserEvent)
+	}
+
+	if config.Readiness.MinMembers < 0 {
+		return nil, fmt.Errorf("invalid readiness min_members: %d (must not be negative)", config.Readiness.MinMembers)
+	}
+
+	if config.Cluster.MaxConcurrentSyncs < 0 {
+		return nil, fmt.Errorf("invalid cluster max_concurrent_syncs: %d (must not be negative)", config.Cluster.MaxConcurrentSyncs)
+	}
+
+	if config.Cluster.BroadcastRetryMaxAge < 0 {
+		return nil, fmt.Errorf("invalid cluster broadcast_retry_max_age: %d (must not be negative)", config.Cluster.BroadcastRetryMaxAge)
+	}
+
+	if config.Cluster.UpdateCoalescePeriod < 0 {
+		return nil, fmt.Errorf("invalid cluster update_coalesce_period: %d (must not be negative)", config.Cluster.UpdateCoalescePeriod)
+	}
+
+	if config.Cluster.MaxSyncSilence < 0 {
+		return nil, fmt.Errorf("invalid cluster max_sync_silence: %d (must not be negative)", config.Cluster.MaxSyncSilence)
+	}
+
+	if config.Cluster.JoinGracePeriod < 0 {
+		return nil, fmt.Errorf("invalid cluster join_grace_period: %d (must not be negative)", config.Cluster.JoinGracePeriod)
+	}
+
+	if config.Cluster.JoinTimeout < 0 {
+		return nil, fmt.Errorf("invalid cluster join_timeout: %d (must be positive)", config.Cluster.JoinTimeout)
+	}
+
+	if config.Node.Database.MemoryBudgetMB < 0 {
+		return nil, fmt.Errorf("invalid database memory_budget_mb: %d (must not be negative)", config.Node.Database.MemoryBudgetMB)
+	}
+
+	switch config.Node.Database.OnNewerSchema {
+	case "", "refuse", "warn":
+	default:
+		return nil, fmt.Errorf("invalid database on_newer_schema %q: must be refuse or warn", config.Node.Database.OnNewerSchema)
+	}
+
+	if config.Node.Database.VacuumInterval < 0 {
+		return nil, fmt.Errorf("invalid database vacuum_interval: %d (must not be negative)", config.Node.Database.VacuumInterval)
+	}
+	if config.Node.Database.VacuumInterval > 0 && !config.Node.Database.IncrementalVacuum {
+		return nil, fmt.Errorf("database vacuum_interval requires incremental_vacuum")
+	}
+
+	if config.Node.HTTP.RequestTimeout < 0 {
+		return nil, fmt.Errorf("invalid http request_timeout: %d (must not be negative)", config.Node.HTTP.RequestTimeout)
+	}
+
+	if config.Node.HTTP.CompressMinSize < 0 {
+		return nil, fmt.Errorf("invalid http compress_min_size: %d (must not be negative)", config.Node.HTTP.CompressMinSize)
+	}
+
+	if (config.Node.HTTP.TLSCertFile == "") != (config.Node.HTTP.TLSKeyFile == "") {
+		return nil, fmt.Errorf("http tls_cert_file and tls_key_file must be set together")
+	}
+
+	if err := config.Cluster.LoadEncryptKey(); err != nil {
+		return nil, err
+	}
+
+	// Validate file modes
+	if _, err := ParseFileMode(config.Node.Database.DirMode); err != nil {
+		return nil, fmt.Errorf("invalid database dir_mode: %w", err)
+	}
+	if _, err := ParseFileMode(config.Node.Database.FileMode); err != nil {
+		return nil, fmt.Errorf("invalid database file_mode: %w", err)
+	}
 
 	return &config, nil
 }
 
+// EncryptKeyEnv is the environment variable the encryption key can be read from
+const EncryptKeyEnv = "ACS_ENCRYPT_KEY"
+
+// LoadEncryptKey resolves EncryptKey from encrypt_key, encrypt_key_file or
+// the ACS_ENCRYPT_KEY env variable and validates it. Only one source may
+// be set.
+func (c *ClusterConfig) LoadEncryptKey() error {
+	envKey := os.Getenv(EncryptKeyEnv)
+
+	sources := 0
+	for _, set := range []bool{c.EncryptKey != "", c.EncryptKeyFile != "", envKey != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("encryption key is set more than once: use only one of encrypt_key, encrypt_key_file and %s", EncryptKeyEnv)
+	}
+
+	switch {
+	case c.EncryptKeyFile != "":
+		data, err := os.ReadFile(c.EncryptKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read encrypt_key_file: %w", err)
+		}
+		c.EncryptKey = strings.TrimSpace(string(data))
+	case envKey != "":
+		c.EncryptKey = strings.TrimSpace(envKey)
+	}
+
+	if _, err := ParseEncryptKey(c.EncryptKey); err != nil {
+		return fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return nil
+}
+
+// ParseEncryptKey decodes a base64 Serf encryption key. It must be 16, 24
+// or 32 bytes long. An empty key returns nil (encryption disabled).
+func ParseEncryptKey(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+
+	switch len(decoded) {
+	case 16, 24, 32:
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("key must be 16, 24 or 32 bytes, got %d", len(decoded))
+	}
+}
+
 // ParseLogLevel converts a log level string to slog.Level
 func ParseLogLevel(level string) slog.Level {
 	switch strings.ToLower(level) {
@@ -92,3 +344,40 @@ func ParseLogLevel(level string) slog.Level {
 		return slog.LevelInfo
 	}
 }
+
+// ParseFileMode converts an octal permission string (e.g. "0600") to os.FileMode.
+// An empty string returns 0, meaning the default should be used.
+func ParseFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("%q is not a valid octal permission", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// Addr returns the HTTP listen address in "host:port" form
+func (c HTTPConfig) Addr() string {
+	return net.JoinHostPort(c.BindAddr, strconv.Itoa(c.Port))
+}
+
+// TLSEnabled returns true if the server should serve HTTPS
+func (c HTTPConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// nodePlaceholder is replaced with the node name in database paths
+const nodePlaceholder = "{node}"
+
+// ExpandNodeName replaces {node} in the database, replica, backup and
+// keyring paths with the node name, so nodes sharing a config or host get their own files.
+// Call it once the node name is final, i.e. after command line overrides.
+func (c *Config) ExpandNodeName() {
+	db := &c.Node.Database
+	db.Path = strings.ReplaceAll(db.Path, nodePlaceholder, c.Node.Name)
+	db.ReplicaPath = strings.ReplaceAll(db.ReplicaPath, nodePlaceholder, c.Node.Name)
+	db.BackupDir = strings.ReplaceAll(db.BackupDir, nodePlaceholder, c.Node.Name)
+	c.Cluster.KeyringFile = strings.ReplaceAll(c.Cluster.KeyringFile, nodePlaceholder, c.Node.Name)
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadEncryptKey(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	keyFile := filepath.Join(t.TempDir(), "encrypt.key")
+	// Mounted secrets usually end with a newline
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		config  ClusterConfig
+		env     string
+		want    string
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "inline", config: ClusterConfig{EncryptKey: key}, want: key},
+		{name: "file", config: ClusterConfig{EncryptKeyFile: keyFile}, want: key},
+		{name: "env", env: key, want: key},
+		{name: "inline and file", config: ClusterConfig{EncryptKey: key, EncryptKeyFile: keyFile}, wantErr: "set more than once"},
+		{name: "inline and env", config: ClusterConfig{EncryptKey: key}, env: key, wantErr: "set more than once"},
+		{name: "file and env", config: ClusterConfig{EncryptKeyFile: keyFile}, env: key, wantErr: "set more than once"},
+		{name: "missing file", config: ClusterConfig{EncryptKeyFile: filepath.Join(t.TempDir(), "missing.key")}, wantErr: "failed to read encrypt_key_file"},
+		{name: "invalid key", env: "not-a-key", wantErr: "invalid encryption key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EncryptKeyEnv, tt.env)
+			c := tt.config
+
+			err := c.LoadEncryptKey()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadEncryptKey = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadEncryptKey: %v", err)
+			}
+			if c.EncryptKey != tt.want {
+				t.Errorf("EncryptKey = %q, want %q", c.EncryptKey, tt.want)
+			}
+		})
+	}
+}
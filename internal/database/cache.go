@@ -0,0 +1,152 @@
+package database
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// todoCache is a small LRU cache of todos keyed by namespace and extern_id.
+// It is safe for concurrent use.
+type todoCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List               // front = most recently used
+	entries map[string]*list.Element // cacheKey -> element
+	byID    map[int]string           // id -> cacheKey
+	gen     uint64                   // incremented on every invalidation
+}
+
+// cacheEntry is a single cached todo
+type cacheEntry struct {
+	todo      models.Todo
+	expiresAt time.Time
+}
+
+// newTodoCache creates a new cache holding at most size todos.
+// A ttl of zero means entries never expire.
+func newTodoCache(size int, ttl time.Duration) *todoCache {
+	return &todoCache{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		byID:    make(map[int]string),
+	}
+}
+
+// cacheKey identifies a todo by namespace and extern_id
+func cacheKey(namespace, externID string) string {
+	return namespace + "\x00" + externID
+}
+
+// generation returns the current invalidation generation. Callers take it
+// before reading from the database and pass it to put, so a row read before
+// a concurrent write is never cached after that write invalidated it.
+func (c *todoCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// getByExternID returns a cached todo by namespace and extern_id
+func (c *todoCache) getByExternID(namespace, externID string) (*models.Todo, bool) {
+	return c.get(cacheKey(namespace, externID))
+}
+
+// get returns a cached todo by cache key
+func (c *todoCache) get(key string) (*models.Todo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	todo := entry.todo
+	return &todo, true
+}
+
+// getByID returns a cached todo by ID
+func (c *todoCache) getByID(id int) (*models.Todo, bool) {
+	c.mu.Lock()
+	key, ok := c.byID[id]
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	return c.get(key)
+}
+
+// put stores a todo read at the given generation
+func (c *todoCache) put(todo *models.Todo, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Skip if the cache was invalidated since the row was read
+	if gen != c.gen {
+		return
+	}
+
+	key := cacheKey(todo.Namespace, todo.ExternID)
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+
+	entry := &cacheEntry{todo: *todo}
+	if c.ttl > 0 {
+		entry.expiresAt = time.Now().Add(c.ttl)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.byID[todo.ID] = key
+
+	// Evict least recently used entries
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// invalidateID removes a todo from the cache by ID
+func (c *todoCache) invalidateID(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if key, ok := c.byID[id]; ok {
+		if elem, ok := c.entries[key]; ok {
+			c.removeElement(elem)
+		}
+		delete(c.byID, id)
+	}
+}
+
+// invalidateExternID removes a todo from the cache by namespace and extern_id
+func (c *todoCache) invalidateExternID(namespace, externID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if elem, ok := c.entries[cacheKey(namespace, externID)]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeElement removes an element from the cache (caller holds the lock)
+func (c *todoCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, cacheKey(entry.todo.Namespace, entry.todo.ExternID))
+	delete(c.byID, entry.todo.ID)
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestTodoCacheSkipsPutsReadBeforeInvalidation(t *testing.T) {
+	c := newTodoCache(10, 0)
+	todo := &models.Todo{ID: 1, Namespace: "default", ExternID: "todo-1", Todo: "old"}
+
+	// A reader takes the generation, then a writer invalidates the todo
+	// before the reader caches the row it read
+	gen := c.generation()
+	c.invalidateID(todo.ID)
+	c.put(todo, gen)
+
+	if cached, ok := c.getByID(todo.ID); ok {
+		t.Fatalf("row read before the invalidation was cached: %+v", cached)
+	}
+
+	c.put(todo, c.generation())
+	if _, ok := c.getByExternID("default", "todo-1"); !ok {
+		t.Fatal("row read after the invalidation was not cached")
+	}
+
+	c.invalidateExternID("default", "todo-1")
+	if _, ok := c.getByID(todo.ID); ok {
+		t.Fatal("todo still cached after invalidateExternID")
+	}
+}
+
+func TestTodoCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTodoCache(2, 0)
+	for id, externID := range []string{"a", "b"} {
+		c.put(&models.Todo{ID: id + 1, Namespace: "default", ExternID: externID}, c.generation())
+	}
+
+	// Touch a, so b is the least recently used
+	c.getByExternID("default", "a")
+	c.put(&models.Todo{ID: 3, Namespace: "default", ExternID: "c"}, c.generation())
+
+	if _, ok := c.getByExternID("default", "b"); ok {
+		t.Error("least recently used todo was not evicted")
+	}
+	if _, ok := c.getByID(2); ok {
+		t.Error("evicted todo still found by id")
+	}
+	for _, externID := range []string{"a", "c"} {
+		if _, ok := c.getByExternID("default", externID); !ok {
+			t.Errorf("todo %s was evicted", externID)
+		}
+	}
+}
+
+func TestTodoCacheExpiresEntries(t *testing.T) {
+	c := newTodoCache(10, 10*time.Millisecond)
+	c.put(&models.Todo{ID: 1, Namespace: "default", ExternID: "todo-1"}, c.generation())
+
+	if _, ok := c.getByID(1); !ok {
+		t.Fatal("fresh todo not cached")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.getByID(1); ok {
+		t.Fatal("expired todo still cached")
+	}
+}
+
+func TestCachedReadsSeeWrites(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "todos.db"), Options{CacheSize: 10})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	todo, err := db.CreateTodo(models.DefaultNamespace, "todo-1", "old", "node-a", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	// Populate the cache
+	if _, err := db.GetTodoByExternID(models.DefaultNamespace, "todo-1"); err != nil {
+		t.Fatalf("GetTodoByExternID: %v", err)
+	}
+
+	text := "new"
+	if _, err := db.UpdateTodo(todo.ID, &text, nil, nil, nil); err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+	got, err := db.GetTodoByExternID(models.DefaultNamespace, "todo-1")
+	if err != nil {
+		t.Fatalf("GetTodoByExternID: %v", err)
+	}
+	if got == nil || got.Todo != "new" {
+		t.Fatalf("read after update = %+v, want text %q", got, "new")
+	}
+
+	if err := db.DeleteTodo(todo.ID); err != nil {
+		t.Fatalf("DeleteTodo: %v", err)
+	}
+	got, err = db.GetTodo(todo.ID)
+	if err != nil {
+		t.Fatalf("GetTodo: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("read after delete = %+v, want nil", got)
+	}
+}
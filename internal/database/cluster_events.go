@@ -0,0 +1,57 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+// RecordClusterEvent persists a cluster membership change
+func (db *DB) RecordClusterEvent(node, eventType, addr string) error {
+	_, err := db.conn.Exec(
+		"INSERT INTO cluster_events (node, event_type, addr, created_at) VALUES (?, ?, ?, ?)",
+		node, eventType, addr, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record cluster event: %w", err)
+	}
+	return nil
+}
+
+// ListClusterEvents retrieves cluster events, newest first
+func (db *DB) ListClusterEvents(limit, offset int) ([]models.ClusterEvent, error) {
+	rows, err := db.reader().Query(
+		"SELECT id, node, event_type, addr, created_at FROM cluster_events ORDER BY id DESC LIMIT ? OFFSET ?",
+		limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.ClusterEvent
+	for rows.Next() {
+		var event models.ClusterEvent
+		if err := rows.Scan(&event.ID, &event.Node, &event.EventType, &event.Addr, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan cluster event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cluster events: %w", err)
+	}
+
+	return events, nil
+}
+
+// CountClusterEvents returns the total number of cluster events
+func (db *DB) CountClusterEvents() (int, error) {
+	var count int
+	err := db.reader().QueryRow("SELECT COUNT(*) FROM cluster_events").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count cluster events: %w", err)
+	}
+	return count, nil
+}
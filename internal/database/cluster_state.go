@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// lamportKey is the cluster_state key of the reserved Lamport time
+const lamportKey = "lamport"
+
+// LamportTime returns the highest Lamport time reserved by this node, or 0
+// if none was saved yet
+func (db *DB) LamportTime() (uint64, error) {
+	var value uint64
+	err := db.conn.QueryRow("SELECT value FROM cluster_state WHERE key = ?", lamportKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read lamport time: %w", err)
+	}
+	return value, nil
+}
+
+// SaveLamportTime persists a reserved Lamport time. The stored value never
+// decreases, so a late save cannot undo a higher reservation.
+func (db *DB) SaveLamportTime(value uint64) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO cluster_state (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = MAX(value, excluded.value)`,
+		lamportKey, value,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save lamport time: %w", err)
+	}
+	return nil
+}
@@ -2,67 +2,438 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
 	_ "modernc.org/sqlite"
 )
 
+// ErrVersionMismatch is returned when a conditional write finds a different version
+var ErrVersionMismatch = errors.New("todo version mismatch")
+
+// todoColumns lists the columns selected for a todo, in scanTodo order
+const todoColumns = "id, namespace, extern_id, todo, completed, version, created_at, completed_at, updated_at, expires_at, origin_node, metadata"
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTodo scans a row selected with todoColumns into a todo
+func scanTodo(row rowScanner, todo *models.Todo) error {
+	return row.Scan(&todo.ID, &todo.Namespace, &todo.ExternID, &todo.Todo, &todo.Completed, &todo.Version, &todo.CreatedAt, &todo.CompletedAt, &todo.UpdatedAt, &todo.ExpiresAt, &todo.OriginNode, &todo.Metadata)
+}
+
 // DB wraps the database connection
 type DB struct {
-	conn *sql.DB
+	conn    *sql.DB
+	replica *sql.DB    // read-only connection for reads, nil if not configured
+	cache   *todoCache // nil if caching is disabled
+	lock    *os.File   // held lock file, nil for in-memory databases
+}
+
+// Options contains optional database settings
+type Options struct {
+	CacheSize int           // Maximum number of cached todos (0 disables the cache)
+	CacheTTL  time.Duration // Maximum age of cached todos (0 means no expiry)
+	DirMode   os.FileMode   // Permissions for created parent directories (default 0700)
+	FileMode  os.FileMode   // Permissions for the database file (default 0600)
+
+	// MemoryBudgetMB sizes SQLite's page cache and mmap per connection
+	// (0 keeps SQLite's defaults). See memoryPragmas.
+	MemoryBudgetMB int
+
+	// IncrementalVacuum enables incremental auto_vacuum so space freed by
+	// deletes can be reclaimed with DB.IncrementalVacuum
+	IncrementalVacuum bool
+
+	// AllowNewerSchema opens databases migrated by a newer binary instead
+	// of failing with ErrSchemaTooNew
+	AllowNewerSchema bool
+
+	// ReplicaPath is an optional read-only copy of the database (e.g. kept
+	// up to date by an external replication tool). Reads are served from
+	// it while writes always go to the primary.
+	ReplicaPath string
 }
 
 // New creates a new database connection and initializes the schema
-func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite", dbPath)
+func New(dbPath string, opts Options) (*DB, error) {
+	if err := prepareFile(dbPath, opts); err != nil {
+		return nil, err
+	}
+
+	// Keep a second instance from using the same file
+	var lock *os.File
+	if isFilePath(dbPath) {
+		var err error
+		lock, err = lockFile(dbPath, fileModeOrDefault(opts.FileMode))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := sql.Open("sqlite", withPragmas(dbPath, memoryPragmas(opts.MemoryBudgetMB)))
 	if err != nil {
+		if lock != nil {
+			lock.Close()
+		}
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	db := &DB{conn: conn, lock: lock}
 	if err := conn.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", describeLocked(dbPath, err))
+	}
+
+	if err := db.checkIntegrity(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database %q failed integrity check: %w", dbPath, describeLocked(dbPath, err))
 	}
 
-	db := &DB{conn: conn}
+	if err := db.checkSchemaVersion(opts.AllowNewerSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database %q: %w", dbPath, describeLocked(dbPath, err))
+	}
+
+	if opts.CacheSize > 0 {
+		db.cache = newTodoCache(opts.CacheSize, opts.CacheTTL)
+	}
+	if opts.IncrementalVacuum {
+		if err := db.enableIncrementalVacuum(); err != nil {
+			db.Close()
+			return nil, describeLocked(dbPath, err)
+		}
+	}
 	if err := db.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", describeLocked(dbPath, err))
+	}
+	if err := db.recordSchemaVersion(); err != nil {
+		db.Close()
+		return nil, describeLocked(dbPath, err)
+	}
+
+	if opts.ReplicaPath != "" {
+		replica, err := openReplica(opts.ReplicaPath)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		db.replica = replica
 	}
 
 	return db, nil
 }
 
+// openReplica opens a read-only connection to a replica database
+func openReplica(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open replica database: %w", err)
+	}
+
+	replica, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replica database: %w", err)
+	}
+
+	if err := replica.Ping(); err != nil {
+		replica.Close()
+		return nil, fmt.Errorf("failed to ping replica database: %w", err)
+	}
+
+	return replica, nil
+}
+
+// reader returns the connection used for reads
+func (db *DB) reader() *sql.DB {
+	if db.replica != nil {
+		return db.replica
+	}
+	return db.conn
+}
+
+// memoryPragmas derives page cache and mmap pragmas from a memory budget.
+// A quarter of the budget goes to SQLite's page cache, which lives on the
+// heap, and the rest to mmap, which the OS can reclaim under pressure.
+func memoryPragmas(budgetMB int) []string {
+	if budgetMB <= 0 {
+		return nil
+	}
+
+	budget := int64(budgetMB) << 20
+	cacheKiB := budget / 4 >> 10
+	mmapBytes := budget - budget/4
+
+	return []string{
+		fmt.Sprintf("cache_size(-%d)", cacheKiB), // negative means KiB instead of pages
+		fmt.Sprintf("mmap_size(%d)", mmapBytes),
+	}
+}
+
+// withPragmas appends pragmas to a database path as _pragma query
+// parameters, so they are applied to every pooled connection
+func withPragmas(dbPath string, pragmas []string) string {
+	if len(pragmas) == 0 {
+		return dbPath
+	}
+
+	params := url.Values{"_pragma": pragmas}
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return dbPath + sep + params.Encode()
+}
+
+// prepareFile creates the parent directory of the database file if missing
+// and ensures the file exists with the configured permissions
+func prepareFile(dbPath string, opts Options) error {
+	if !isFilePath(dbPath) {
+		return nil
+	}
+
+	dirMode := opts.DirMode
+	if dirMode == 0 {
+		dirMode = 0700
+	}
+	fileMode := fileModeOrDefault(opts.FileMode)
+
+	dir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return fmt.Errorf("failed to create database directory %q: %w", dir, err)
+	}
+
+	f, err := os.OpenFile(dbPath, os.O_RDWR|os.O_CREATE, fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open database file %q: %w", dbPath, err)
+	}
+	f.Close()
+
+	// OpenFile only applies the mode to new files
+	if err := os.Chmod(dbPath, fileMode); err != nil {
+		return fmt.Errorf("failed to set permissions on database file %q: %w", dbPath, err)
+	}
+
+	return nil
+}
+
+// isFilePath returns false for in-memory and URI style database paths
+func isFilePath(dbPath string) bool {
+	return dbPath != ":memory:" && !strings.HasPrefix(dbPath, "file:")
+}
+
+// fileModeOrDefault returns the configured database file mode, or 0600
+func fileModeOrDefault(mode os.FileMode) os.FileMode {
+	if mode == 0 {
+		return 0600
+	}
+	return mode
+}
+
+// checkIntegrity runs SQLite's quick_check so a corrupted file is rejected
+// at startup instead of failing later queries
+func (db *DB) checkIntegrity() error {
+	rows, err := db.conn.Query("PRAGMA quick_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("failed to scan integrity check result: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("database is corrupted: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 // initSchema creates the database schema
 func (db *DB) initSchema() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS todos (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		namespace TEXT NOT NULL DEFAULT 'default',
 		extern_id TEXT NOT NULL,
 		todo TEXT NOT NULL,
 		completed BOOLEAN NOT NULL DEFAULT 0,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		version INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		completed_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		origin_node TEXT NOT NULL DEFAULT '',
+		metadata TEXT,
+		updated_at TIMESTAMP
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_todos_created_at ON todos(created_at);
 	CREATE INDEX IF NOT EXISTS idx_todos_completed ON todos(completed);
-	CREATE UNIQUE INDEX IF NOT EXISTS idx_todos_extern_id ON todos(extern_id);
+
+	CREATE TABLE IF NOT EXISTS cluster_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		node TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		addr TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS cluster_state (
+		key TEXT PRIMARY KEY,
+		value INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS broadcast_outbox (
+		key TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		coalesce BOOLEAN NOT NULL DEFAULT 0,
+		queued_at TIMESTAMP NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 1,
+		next_attempt_at TIMESTAMP NOT NULL
+	);
 	`
 
-	_, err := db.conn.Exec(schema)
+	if _, err := db.conn.Exec(schema); err != nil {
+		return err
+	}
+
+	// Add columns introduced after the initial schema to existing databases
+	columns := []struct{ name, definition string }{
+		{"version", "INTEGER NOT NULL DEFAULT 1"},
+		{"completed_at", "TIMESTAMP"},
+		{"expires_at", "TIMESTAMP"},
+		{"namespace", "TEXT NOT NULL DEFAULT 'default'"},
+		{"origin_node", "TEXT NOT NULL DEFAULT ''"},
+		{"metadata", "TEXT"},
+		{"updated_at", "TIMESTAMP"},
+	}
+	for _, column := range columns {
+		if err := db.addColumnIfMissing("todos", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err := db.addColumnIfMissing("broadcast_outbox", "coalesce", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Todos written before updated_at existed were last changed no later
+	// than they were created, as far as we know
+	_, err := db.conn.Exec(`
+	UPDATE todos SET updated_at = created_at WHERE updated_at IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_todos_updated_at ON todos(updated_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Databases from before the unique index may hold duplicates, which
+	// would make creating it fail
+	var hasUniqueIndex bool
+	err = db.conn.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'index' AND name = 'idx_todos_namespace_extern_id'").Scan(&hasUniqueIndex)
+	if err != nil {
+		return fmt.Errorf("failed to inspect indexes: %w", err)
+	}
+	if !hasUniqueIndex {
+		if err := db.mergeDuplicateTodos(); err != nil {
+			return err
+		}
+	}
+
+	// extern_id is unique per namespace; the index needs the namespace
+	// column, so it is created once older databases have been migrated
+	_, err = db.conn.Exec(`
+	DROP INDEX IF EXISTS idx_todos_extern_id;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_todos_namespace_extern_id ON todos(namespace, extern_id);
+	`)
 	return err
 }
 
-// Close closes the database connection
+// mergeDuplicateTodos deletes all but the most recently changed todo of
+// each namespace and extern_id, keeping the highest id on a tie
+func (db *DB) mergeDuplicateTodos() error {
+	_, err := db.conn.Exec(`
+	DELETE FROM todos WHERE id IN (
+		SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY namespace, extern_id
+				ORDER BY updated_at DESC, id DESC
+			) AS n FROM todos
+		) WHERE n > 1
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to merge duplicate todos: %w", err)
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table unless it already exists
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to scan column info: %w", err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating column info: %w", err)
+	}
+
+	_, err = db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// Close closes the database connections
 func (db *DB) Close() error {
-	return db.conn.Close()
+	if db.replica != nil {
+		db.replica.Close()
+	}
+	err := db.conn.Close()
+	if db.lock != nil {
+		db.lock.Close()
+	}
+	return err
 }
 
-// CreateTodo creates a new todo item
-func (db *DB) CreateTodo(externID, todo string) (*models.Todo, error) {
+// CreateTodo creates a new todo item in a namespace, recording the node it
+// was created on. metadata and expiresAt are optional.
+func (db *DB) CreateTodo(namespace, externID, todo, originNode string, metadata models.Metadata, expiresAt *time.Time) (*models.Todo, error) {
+	now := time.Now()
 	result, err := db.conn.Exec(
-		"INSERT INTO todos (extern_id, todo, completed, created_at) VALUES (?, ?, ?, ?)",
-		externID, todo, false, time.Now(),
+		"INSERT INTO todos (namespace, extern_id, todo, completed, created_at, updated_at, expires_at, origin_node, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		namespace, externID, todo, false, now, now, localTime(expiresAt), originNode, metadata,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create todo: %w", err)
@@ -73,57 +444,334 @@ func (db *DB) CreateTodo(externID, todo string) (*models.Todo, error) {
 		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
 
-	return db.GetTodo(int(id))
+	return db.getTodo(int(id), true)
+}
+
+// UpsertTodo creates a todo or, if one with the same extern_id already
+// exists in the namespace, overwrites its text, completed state and expiry.
+// An empty originNode keeps the recorded origin and nil metadata the
+// recorded metadata.
+func (db *DB) UpsertTodo(namespace, externID, todo, originNode string, completed bool, metadata models.Metadata, expiresAt *time.Time) (*models.Todo, error) {
+	now := time.Now()
+	var completedAt *time.Time
+	if completed {
+		completedAt = &now
+	}
+
+	_, err := db.conn.Exec(
+		`INSERT INTO todos (namespace, extern_id, todo, completed, created_at, updated_at, completed_at, expires_at, origin_node, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(namespace, extern_id) DO UPDATE SET
+			todo = excluded.todo,
+			completed = excluded.completed,
+			completed_at = CASE WHEN NOT excluded.completed THEN NULL WHEN completed THEN completed_at ELSE excluded.completed_at END,
+			expires_at = excluded.expires_at,
+			origin_node = CASE WHEN excluded.origin_node = '' THEN origin_node ELSE excluded.origin_node END,
+			metadata = COALESCE(excluded.metadata, metadata),
+			updated_at = excluded.updated_at,
+			version = version + 1`,
+		namespace, externID, todo, completed, now, now, completedAt, localTime(expiresAt), originNode, metadata,
+	)
+	if db.cache != nil {
+		db.cache.invalidateExternID(namespace, externID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert todo: %w", err)
+	}
+
+	return db.getTodoByExternID(namespace, externID, true)
+}
+
+// PutTodoByExternID creates a todo with the given extern_id in a namespace
+// or, if one exists, updates its text and (if set) completed state, in a single
+// transaction. originNode and expiresAt are only recorded for new todos.
+// Returns whether the todo was created.
+func (db *DB) PutTodoByExternID(namespace, externID, todo, originNode string, completed *bool, expiresAt *time.Time) (*models.Todo, bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	var wasCompleted bool
+	err = tx.QueryRow("SELECT id, completed FROM todos WHERE namespace = ? AND extern_id = ?", namespace, externID).Scan(&id, &wasCompleted)
+	created := err == sql.ErrNoRows
+	if err != nil && !created {
+		return nil, false, fmt.Errorf("failed to get todo by extern_id: %w", err)
+	}
+
+	now := time.Now()
+	if created {
+		isCompleted := completed != nil && *completed
+		var completedAt *time.Time
+		if isCompleted {
+			completedAt = &now
+		}
+		_, err = tx.Exec(
+			"INSERT INTO todos (namespace, extern_id, todo, completed, created_at, updated_at, completed_at, expires_at, origin_node) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			namespace, externID, todo, isCompleted, now, now, completedAt, localTime(expiresAt), originNode,
+		)
+	} else {
+		query := "UPDATE todos SET todo = ?, updated_at = ?, version = version + 1"
+		args := []interface{}{todo, now}
+		if completed != nil {
+			query += ", completed = ?"
+			args = append(args, *completed)
+			if !*completed {
+				query += ", completed_at = NULL"
+			} else if !wasCompleted {
+				query += ", completed_at = ?"
+				args = append(args, now)
+			}
+		}
+		query += " WHERE id = ?"
+		args = append(args, id)
+		_, err = tx.Exec(query, args...)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to put todo: %w", err)
+	}
+
+	err = tx.Commit()
+	if db.cache != nil {
+		db.cache.invalidateExternID(namespace, externID)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to commit todo: %w", err)
+	}
+
+	result, err := db.getTodoByExternID(namespace, externID, true)
+	return result, created, err
 }
 
 // GetTodo retrieves a todo by ID
 func (db *DB) GetTodo(id int) (*models.Todo, error) {
+	return db.getTodo(id, false)
+}
+
+// getTodo retrieves a todo by ID, reading from the primary if requested
+func (db *DB) getTodo(id int, primary bool) (*models.Todo, error) {
+	var gen uint64
+	if db.cache != nil {
+		if todo, ok := db.cache.getByID(id); ok {
+			return todo, nil
+		}
+		gen = db.cache.generation()
+	}
+
+	todo, fromPrimary, err := db.selectTodo(primary, "id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get todo: %w", err)
+	}
+
+	if todo != nil && fromPrimary && db.cache != nil {
+		db.cache.put(todo, gen)
+	}
+
+	return todo, nil
+}
+
+// selectTodo selects a single todo matching the condition. Returns nil if
+// no todo matches. A todo missing on the replica is looked up on the
+// primary, since the replica may lag behind recent writes. Also returns
+// whether the todo was read from the primary; only those may be cached,
+// since the replica may still hold a copy older than the last write.
+func (db *DB) selectTodo(primary bool, condition string, args ...interface{}) (*models.Todo, bool, error) {
+	conn := db.reader()
+	if primary {
+		conn = db.conn
+	}
+
 	var todo models.Todo
-	err := db.conn.QueryRow(
-		"SELECT id, extern_id, todo, completed, created_at FROM todos WHERE id = ?",
-		id,
-	).Scan(&todo.ID, &todo.ExternID, &todo.Todo, &todo.Completed, &todo.CreatedAt)
+	err := scanTodo(conn.QueryRow(
+		"SELECT "+todoColumns+" FROM todos WHERE "+condition,
+		args...,
+	), &todo)
 
 	if err == sql.ErrNoRows {
-		return nil, nil
+		if conn != db.conn {
+			return db.selectTodo(true, condition, args...)
+		}
+		return nil, true, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get todo: %w", err)
+		return nil, false, err
 	}
 
-	return &todo, nil
+	return &todo, conn == db.conn, nil
+}
+
+// ListOptions contains optional filters and ordering for listing todos
+type ListOptions struct {
+	Namespace     string            // only todos in this namespace (default all)
+	CreatedAfter  *time.Time        // inclusive lower bound on created_at
+	CreatedBefore *time.Time        // exclusive upper bound on created_at
+	AfterID       int               // only todos with a greater id, for paging by id
+	Labels        map[string]string // only todos whose metadata has all these entries; keys must match models.MetadataKeyPattern
+	SortBy        string            // one of sortColumns keys (default "created_at")
+	Order         string            // "asc" or "desc" (default "desc")
+	Primary       bool              // read from the primary even if a replica is configured
+}
+
+// sortColumns maps allowed sort keys to their SQL column names
+var sortColumns = map[string]string{
+	"created_at": "created_at",
+	"id":         "id",
+	"completed":  "completed",
 }
 
 // ListTodos retrieves all todos
 func (db *DB) ListTodos() ([]models.Todo, error) {
-	rows, err := db.conn.Query(
-		"SELECT id, extern_id, todo, completed, created_at FROM todos ORDER BY created_at DESC",
-	)
+	return db.ListTodosWithOptions(ListOptions{})
+}
+
+// ListTodosWithOptions retrieves all todos matching the given options
+func (db *DB) ListTodosWithOptions(opts ListOptions) ([]models.Todo, error) {
+	var todos []models.Todo
+	err := db.EachTodo(opts, func(todo *models.Todo) error {
+		todos = append(todos, *todo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+// EachTodo calls fn for every todo matching the given options, reading one
+// row at a time so memory use does not grow with the number of todos.
+// Iteration stops at the first error returned by fn.
+//
+// The read holds a lock on the database until iteration ends, so a slow fn
+// delays writes unless reads go to a replica.
+func (db *DB) EachTodo(opts ListOptions, fn func(todo *models.Todo) error) error {
+	query, args, err := buildListQuery(opts)
+	if err != nil {
+		return err
+	}
+
+	conn := db.reader()
+	if opts.Primary {
+		conn = db.conn
+	}
+	rows, err := conn.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list todos: %w", err)
+		return fmt.Errorf("failed to list todos: %w", err)
 	}
 	defer rows.Close()
 
-	var todos []models.Todo
 	for rows.Next() {
 		var todo models.Todo
-		if err := rows.Scan(&todo.ID, &todo.ExternID, &todo.Todo, &todo.Completed, &todo.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan todo: %w", err)
+		if err := scanTodo(rows, &todo); err != nil {
+			return fmt.Errorf("failed to scan todo: %w", err)
+		}
+		if err := fn(&todo); err != nil {
+			return err
 		}
-		todos = append(todos, todo)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating todos: %w", err)
+		return fmt.Errorf("error iterating todos: %w", err)
 	}
 
-	return todos, nil
+	return nil
+}
+
+// buildListQuery builds the SELECT statement and arguments for listing todos
+func buildListQuery(opts ListOptions) (string, []interface{}, error) {
+	query := "SELECT " + todoColumns + " FROM todos"
+	args := []interface{}{}
+	conditions := []string{}
+
+	if opts.Namespace != "" {
+		conditions = append(conditions, "namespace = ?")
+		args = append(args, opts.Namespace)
+	}
+
+	// Times are stored in local time, so compare in the same location
+	if opts.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, opts.CreatedAfter.In(time.Local))
+	}
+	if opts.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, opts.CreatedBefore.In(time.Local))
+	}
+
+	if opts.AfterID > 0 {
+		conditions = append(conditions, "id > ?")
+		args = append(args, opts.AfterID)
+	}
+
+	// Sorted for a stable statement; keys are validated, so quoting them
+	// in the JSON path is safe
+	for _, key := range slices.Sorted(maps.Keys(opts.Labels)) {
+		if !models.MetadataKeyPattern.MatchString(key) {
+			return "", nil, fmt.Errorf("invalid label key %q", key)
+		}
+		conditions = append(conditions, "json_extract(metadata, ?) = ?")
+		args = append(args, `$."`+key+`"`, opts.Labels[key])
+	}
+
+	if len(conditions) > 0 {
+		query += " WHERE " + conditions[0]
+		for i := 1; i < len(conditions); i++ {
+			query += " AND " + conditions[i]
+		}
+	}
+
+	orderBy, err := buildOrderBy(opts.SortBy, opts.Order)
+	if err != nil {
+		return "", nil, err
+	}
+	query += orderBy
+
+	return query, args, nil
+}
+
+// buildOrderBy builds an ORDER BY clause from whitelisted values only
+func buildOrderBy(sortBy, order string) (string, error) {
+	if sortBy == "" {
+		sortBy = "created_at"
+	}
+	column, ok := sortColumns[sortBy]
+	if !ok {
+		return "", fmt.Errorf("invalid sort column %q", sortBy)
+	}
+
+	direction := "DESC"
+	switch strings.ToLower(order) {
+	case "", "desc":
+	case "asc":
+		direction = "ASC"
+	default:
+		return "", fmt.Errorf("invalid sort order %q", order)
+	}
+
+	// Use id as tie-breaker for a stable order
+	clause := " ORDER BY " + column + " " + direction
+	if column != "id" {
+		clause += ", id " + direction
+	}
+	return clause, nil
 }
 
-// UpdateTodo updates a todo item
-func (db *DB) UpdateTodo(id int, todo *string, completed *bool) (*models.Todo, error) {
-	// First check if the todo exists
-	existing, err := db.GetTodo(id)
+// UpdateTodo updates a todo item. Nil fields, including nil metadata and
+// expiresAt, are left unchanged.
+func (db *DB) UpdateTodo(id int, todo *string, completed *bool, metadata models.Metadata, expiresAt *time.Time) (*models.Todo, error) {
+	return db.updateTodo(id, nil, todo, completed, metadata, expiresAt)
+}
+
+// UpdateTodoIfVersion updates a todo item only if its current version matches.
+// Returns ErrVersionMismatch if the todo was modified since that version.
+func (db *DB) UpdateTodoIfVersion(id int, version int, todo *string, completed *bool, metadata models.Metadata, expiresAt *time.Time) (*models.Todo, error) {
+	return db.updateTodo(id, &version, todo, completed, metadata, expiresAt)
+}
+
+// updateTodo updates a todo item, optionally conditional on its version
+func (db *DB) updateTodo(id int, version *int, todo *string, completed *bool, metadata models.Metadata, expiresAt *time.Time) (*models.Todo, error) {
+	// First check if the todo exists, on the primary to see the latest version
+	existing, err := db.getTodo(id, true)
 	if err != nil {
 		return nil, err
 	}
@@ -143,6 +791,26 @@ func (db *DB) UpdateTodo(id int, todo *string, completed *bool) (*models.Todo, e
 	if completed != nil {
 		updates = append(updates, "completed = ?")
 		args = append(args, *completed)
+
+		// Track when a todo was completed for TTL after completion
+		if !*completed {
+			updates = append(updates, "completed_at = NULL")
+		} else if !existing.Completed {
+			updates = append(updates, "completed_at = ?")
+			args = append(args, time.Now())
+		}
+	}
+	if metadata != nil {
+		updates = append(updates, "metadata = ?")
+		args = append(args, metadata)
+	}
+	if expiresAt != nil {
+		updates = append(updates, "expires_at = ?")
+		args = append(args, localTime(expiresAt))
+	}
+
+	if version != nil && existing.Version != *version {
+		return nil, ErrVersionMismatch
 	}
 
 	if len(updates) == 0 {
@@ -150,6 +818,9 @@ func (db *DB) UpdateTodo(id int, todo *string, completed *bool) (*models.Todo, e
 		return existing, nil
 	}
 
+	updates = append(updates, "updated_at = ?", "version = version + 1")
+	args = append(args, time.Now())
+
 	query += updates[0]
 	for i := 1; i < len(updates); i++ {
 		query += ", " + updates[i]
@@ -157,17 +828,54 @@ func (db *DB) UpdateTodo(id int, todo *string, completed *bool) (*models.Todo, e
 	query += " WHERE id = ?"
 	args = append(args, id)
 
-	_, err = db.conn.Exec(query, args...)
+	// Re-check the version in the statement itself to avoid a race with
+	// concurrent writers between the read above and this update
+	if version != nil {
+		query += " AND version = ?"
+		args = append(args, *version)
+	}
+
+	result, err := db.conn.Exec(query, args...)
+	db.invalidate(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update todo: %w", err)
 	}
 
-	return db.GetTodo(id)
+	if version != nil {
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rows == 0 {
+			return nil, ErrVersionMismatch
+		}
+	}
+
+	return db.getTodo(id, true)
 }
 
 // DeleteTodo deletes a todo by ID
 func (db *DB) DeleteTodo(id int) error {
-	result, err := db.conn.Exec("DELETE FROM todos WHERE id = ?", id)
+	return db.deleteTodo(id, nil)
+}
+
+// DeleteTodoIfVersion deletes a todo only if its current version matches.
+// Returns ErrVersionMismatch if the todo was modified since that version.
+func (db *DB) DeleteTodoIfVersion(id int, version int) error {
+	return db.deleteTodo(id, &version)
+}
+
+// deleteTodo deletes a todo, optionally conditional on its version
+func (db *DB) deleteTodo(id int, version *int) error {
+	query := "DELETE FROM todos WHERE id = ?"
+	args := []interface{}{id}
+	if version != nil {
+		query += " AND version = ?"
+		args = append(args, *version)
+	}
+
+	result, err := db.conn.Exec(query, args...)
+	db.invalidate(id)
 	if err != nil {
 		return fmt.Errorf("failed to delete todo: %w", err)
 	}
@@ -178,36 +886,168 @@ func (db *DB) DeleteTodo(id int) error {
 	}
 
 	if rows == 0 {
+		// Tell a stale version apart from a missing todo
+		if version != nil {
+			existing, err := db.getTodo(id, true)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return ErrVersionMismatch
+			}
+		}
 		return sql.ErrNoRows
 	}
 
 	return nil
 }
 
-// GetTodoByExternID retrieves a todo by external ID
-func (db *DB) GetTodoByExternID(externID string) (*models.Todo, error) {
-	var todo models.Todo
-	err := db.conn.QueryRow(
-		"SELECT id, extern_id, todo, completed, created_at FROM todos WHERE extern_id = ?",
-		externID,
-	).Scan(&todo.ID, &todo.ExternID, &todo.Todo, &todo.Completed, &todo.CreatedAt)
+// GetTodoByExternID retrieves a todo by namespace and external ID
+func (db *DB) GetTodoByExternID(namespace, externID string) (*models.Todo, error) {
+	return db.getTodoByExternID(namespace, externID, false)
+}
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+// getTodoByExternID retrieves a todo by namespace and external ID, reading
+// from the primary if requested
+func (db *DB) getTodoByExternID(namespace, externID string, primary bool) (*models.Todo, error) {
+	var gen uint64
+	if db.cache != nil {
+		if todo, ok := db.cache.getByExternID(namespace, externID); ok {
+			return todo, nil
+		}
+		gen = db.cache.generation()
 	}
+
+	todo, fromPrimary, err := db.selectTodo(primary, "namespace = ? AND extern_id = ?", namespace, externID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get todo by extern_id: %w", err)
 	}
 
-	return &todo, nil
+	if todo != nil && fromPrimary && db.cache != nil {
+		db.cache.put(todo, gen)
+	}
+
+	return todo, nil
+}
+
+// invalidate removes a todo from the cache after it was written
+func (db *DB) invalidate(id int) {
+	if db.cache != nil {
+		db.cache.invalidateID(id)
+	}
 }
 
 // CountTodos returns the total number of todos
 func (db *DB) CountTodos() (int, error) {
 	var count int
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM todos").Scan(&count)
+	err := db.reader().QueryRow("SELECT COUNT(*) FROM todos").Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count todos: %w", err)
 	}
 	return count, nil
 }
+
+// CheckWritable verifies that the database accepts writes, e.g. that the
+// file is not read-only, without changing anything
+func (db *DB) CheckWritable() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Any write statement opens a write transaction, even if it matches no rows
+	if _, err := tx.Exec("DELETE FROM todos WHERE 0"); err != nil {
+		return fmt.Errorf("database is not writable: %w", err)
+	}
+	return nil
+}
+
+// LastUpdatedAt returns when a todo was last created or changed on this
+// node, nil if there are no todos. It reads the primary, since a lagging
+// replica would understate how fresh this node is.
+func (db *DB) LastUpdatedAt() (*time.Time, error) {
+	var updatedAt time.Time
+	err := db.conn.QueryRow("SELECT updated_at FROM todos ORDER BY updated_at DESC LIMIT 1").Scan(&updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last update time: %w", err)
+	}
+	return &updatedAt, nil
+}
+
+// SampleTodos returns up to n randomly chosen todos from the primary, for
+// comparing them with other nodes
+func (db *DB) SampleTodos(n int) ([]models.Todo, error) {
+	rows, err := db.conn.Query("SELECT "+todoColumns+" FROM todos ORDER BY RANDOM() LIMIT ?", n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample todos: %w", err)
+	}
+	defer rows.Close()
+
+	var todos []models.Todo
+	for rows.Next() {
+		var todo models.Todo
+		if err := scanTodo(rows, &todo); err != nil {
+			return nil, fmt.Errorf("failed to scan todo: %w", err)
+		}
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+// ListExpiredTodos returns todos that have expired at now: todos past their
+// expires_at, older than afterCreation, or completed longer than
+// afterCompletion ago. A zero duration disables that rule.
+func (db *DB) ListExpiredTodos(now time.Time, afterCreation, afterCompletion time.Duration) ([]models.Todo, error) {
+	// Times are stored in local time, so compare in the same location
+	now = now.In(time.Local)
+
+	conditions := []string{"(expires_at IS NOT NULL AND expires_at <= ?)"}
+	args := []interface{}{now}
+
+	if afterCreation > 0 {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, now.Add(-afterCreation))
+	}
+	if afterCompletion > 0 {
+		conditions = append(conditions, "(completed AND completed_at IS NOT NULL AND completed_at <= ?)")
+		args = append(args, now.Add(-afterCompletion))
+	}
+
+	rows, err := db.conn.Query(
+		"SELECT "+todoColumns+" FROM todos WHERE "+strings.Join(conditions, " OR ")+" ORDER BY id",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired todos: %w", err)
+	}
+	defer rows.Close()
+
+	var todos []models.Todo
+	for rows.Next() {
+		var todo models.Todo
+		if err := scanTodo(rows, &todo); err != nil {
+			return nil, fmt.Errorf("failed to scan todo: %w", err)
+		}
+		todos = append(todos, todo)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating expired todos: %w", err)
+	}
+
+	return todos, nil
+}
+
+// localTime converts an optional time to local time, matching how other
+// timestamps are stored
+func localTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(time.Local)
+	return &local
+}
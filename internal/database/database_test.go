@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestNewMergesDuplicateTodos(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+
+	// A database from before namespaces, updated_at and the unique index
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE todos (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		extern_id TEXT NOT NULL,
+		todo TEXT NOT NULL,
+		completed BOOLEAN NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_todos_extern_id ON todos(extern_id);
+	INSERT INTO todos (extern_id, todo, created_at) VALUES
+		('todo-1', 'Buy milk', '2024-01-01 10:00:00'),
+		('todo-1', 'Buy oat milk', '2024-01-02 10:00:00'),
+		('todo-2', 'Buy bread', '2024-01-01 10:00:00'),
+		('todo-1', 'Buy soy milk', '2024-01-01 12:00:00'),
+		('todo-2', 'Buy rye bread', '2024-01-01 10:00:00');
+	`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("creating legacy database: %v", err)
+	}
+
+	db, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	todos, err := db.ListTodosWithOptions(ListOptions{SortBy: "id", Order: "asc"})
+	if err != nil {
+		t.Fatalf("ListTodosWithOptions: %v", err)
+	}
+	got := make(map[string]string)
+	for _, todo := range todos {
+		if _, dup := got[todo.ExternID]; dup {
+			t.Errorf("todo %s still duplicated", todo.ExternID)
+		}
+		got[todo.ExternID] = todo.Todo
+	}
+	// The most recently changed copy wins, the later one on a tie
+	want := map[string]string{"todo-1": "Buy oat milk", "todo-2": "Buy rye bread"}
+	for externID, text := range want {
+		if got[externID] != text {
+			t.Errorf("todo %s = %q, want %q", externID, got[externID], text)
+		}
+	}
+
+	// The unique index now prevents new duplicates
+	if _, err := db.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-a", nil, nil); err == nil {
+		t.Error("created a duplicate todo after the merge")
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrDatabaseLocked is returned by New when another process holds a lock
+// on the database file
+var ErrDatabaseLocked = errors.New("database file is locked, is another instance running?")
+
+// lockFileSuffix is appended to the database path to get the lock file
+const lockFileSuffix = ".lock"
+
+// isLocked returns true if err is SQLite's busy or locked error
+func isLocked(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Extended result codes keep the primary code in the low byte
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// describeLocked replaces SQLite's busy and locked errors with
+// ErrDatabaseLocked, naming the file
+func describeLocked(dbPath string, err error) error {
+	if isLocked(err) {
+		return fmt.Errorf("%w (%s): %v", ErrDatabaseLocked, dbPath, err)
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package database
+
+import "os"
+
+// lockFile is a no-op on platforms without flock; a second instance is
+// still detected by SQLite's own locking once it writes
+func lockFile(dbPath string, mode os.FileMode) (*os.File, error) {
+	return nil, nil
+}
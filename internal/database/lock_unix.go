@@ -0,0 +1,30 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on a file next to the database, so a
+// second instance using the same database fails at startup. The lock is
+// released when the returned file is closed or the process exits.
+func lockFile(dbPath string, mode os.FileMode) (*os.File, error) {
+	path := dbPath + lockFileSuffix
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %q: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w (%s)", ErrDatabaseLocked, dbPath)
+		}
+		return nil, fmt.Errorf("failed to lock %q: %w", path, err)
+	}
+	return f, nil
+}
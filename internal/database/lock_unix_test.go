@@ -0,0 +1,34 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewRejectsLockedDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+	db, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if second, err := New(path, Options{}); !errors.Is(err, ErrDatabaseLocked) {
+		if second != nil {
+			second.Close()
+		}
+		t.Fatalf("second New = %v, want ErrDatabaseLocked", err)
+	}
+
+	// Closing releases the lock for the next instance
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	db, err = New(path, Options{})
+	if err != nil {
+		t.Fatalf("New after Close: %v", err)
+	}
+	db.Close()
+}
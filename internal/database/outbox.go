@@ -0,0 +1,85 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// PendingBroadcast is a sync event that failed to broadcast and waits in
+// the outbox to be retried
+type PendingBroadcast struct {
+	Key         string // todo the event is about; a newer event replaces it
+	Name        string
+	Payload     []byte
+	Coalesce    bool // sent as a coalescable Serf user event
+	QueuedAt    time.Time
+	Attempts    int
+	NextAttempt time.Time
+}
+
+// QueueBroadcast stores a failed broadcast, replacing a queued event for
+// the same todo since the newer event supersedes it
+func (db *DB) QueueBroadcast(b PendingBroadcast) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO broadcast_outbox (key, name, payload, coalesce, queued_at, attempts, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET name = excluded.name, payload = excluded.payload, coalesce = excluded.coalesce,
+			queued_at = excluded.queued_at, attempts = excluded.attempts, next_attempt_at = excluded.next_attempt_at`,
+		b.Key, b.Name, b.Payload, b.Coalesce, b.QueuedAt, b.Attempts, b.NextAttempt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to queue broadcast: %w", err)
+	}
+	return nil
+}
+
+// PendingBroadcasts returns all queued broadcasts, oldest first
+func (db *DB) PendingBroadcasts() ([]PendingBroadcast, error) {
+	rows, err := db.conn.Query("SELECT key, name, payload, coalesce, queued_at, attempts, next_attempt_at FROM broadcast_outbox ORDER BY queued_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queued broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []PendingBroadcast
+	for rows.Next() {
+		var b PendingBroadcast
+		if err := rows.Scan(&b.Key, &b.Name, &b.Payload, &b.Coalesce, &b.QueuedAt, &b.Attempts, &b.NextAttempt); err != nil {
+			return nil, fmt.Errorf("failed to scan queued broadcast: %w", err)
+		}
+		pending = append(pending, b)
+	}
+	return pending, rows.Err()
+}
+
+// RescheduleBroadcast records a failed retry of a queued broadcast. Does
+// nothing if the event was replaced by a newer one in the meantime.
+func (db *DB) RescheduleBroadcast(key string, payload []byte, attempts int, nextAttempt time.Time) error {
+	_, err := db.conn.Exec(
+		"UPDATE broadcast_outbox SET attempts = ?, next_attempt_at = ? WHERE key = ? AND payload = ?",
+		attempts, nextAttempt, key, payload,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule broadcast: %w", err)
+	}
+	return nil
+}
+
+// DeleteBroadcast removes the queued broadcast for a todo. With a payload,
+// only that event is removed, so a newer event queued meanwhile is kept.
+// Returns true if the todo has no queued broadcast anymore.
+func (db *DB) DeleteBroadcast(key string, payload []byte) (bool, error) {
+	query, args := "DELETE FROM broadcast_outbox WHERE key = ?", []interface{}{key}
+	if payload != nil {
+		query += " AND payload = ?"
+		args = append(args, payload)
+	}
+	if _, err := db.conn.Exec(query, args...); err != nil {
+		return false, fmt.Errorf("failed to delete queued broadcast: %w", err)
+	}
+
+	var remaining int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM broadcast_outbox WHERE key = ?", key).Scan(&remaining); err != nil {
+		return false, fmt.Errorf("failed to count queued broadcasts: %w", err)
+	}
+	return remaining == 0, nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/c.mueller/auto-cluster-sync-demo/internal/models"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	dir := t.TempDir()
+	primaryPath, replicaPath := filepath.Join(dir, "todos.db"), filepath.Join(dir, "replica.db")
+
+	// The replica lags behind: it misses the latest change to todo-1 and
+	// todo-2 entirely
+	primary, err := New(primaryPath, Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	todo, err := primary.CreateTodo(models.DefaultNamespace, "todo-1", "Buy milk", "node-a", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	if err := primary.Backup(replicaPath); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	text := "Buy oat milk"
+	if _, err := primary.UpdateTodo(todo.ID, &text, nil, nil, nil); err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+	if _, err := primary.CreateTodo(models.DefaultNamespace, "todo-2", "Buy bread", "node-a", nil, nil); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	primary.Close()
+
+	// Stands in for the external process keeping the replica up to date
+	replication, err := New(replicaPath, Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer replication.Close()
+
+	db, err := New(primaryPath, Options{ReplicaPath: replicaPath, CacheSize: 10})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	get := func(externID string) *models.Todo {
+		t.Helper()
+		todo, err := db.GetTodoByExternID(models.DefaultNamespace, externID)
+		if err != nil {
+			t.Fatalf("GetTodoByExternID: %v", err)
+		}
+		return todo
+	}
+
+	t.Run("reads hit the replica", func(t *testing.T) {
+		if got := get("todo-1"); got.Todo != "Buy milk" {
+			t.Fatalf("todo-1 = %q, want the replica's %q", got.Todo, "Buy milk")
+		}
+		// Not cached, so reads see the replica catching up
+		replicated := "Buy oat milk"
+		if _, err := replication.UpdateTodo(todo.ID, &replicated, nil, nil, nil); err != nil {
+			t.Fatalf("UpdateTodo: %v", err)
+		}
+		if got := get("todo-1"); got.Todo != replicated {
+			t.Errorf("todo-1 = %q after the replica caught up, want %q", got.Todo, replicated)
+		}
+		// Missing on the replica, so looked up on the primary
+		if got := get("todo-2"); got == nil {
+			t.Error("todo-2 missing on the replica was not found on the primary")
+		}
+	})
+
+	t.Run("writes hit the primary", func(t *testing.T) {
+		if _, err := db.CreateTodo(models.DefaultNamespace, "todo-3", "Buy eggs", "node-a", nil, nil); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+		if got, _ := replication.GetTodoByExternID(models.DefaultNamespace, "todo-3"); got != nil {
+			t.Error("write went to the replica")
+		}
+		if got := get("todo-3"); got == nil || got.Todo != "Buy eggs" {
+			t.Errorf("todo-3 = %+v, want it read back from the primary", got)
+		}
+	})
+
+	t.Run("cluster reads hit the primary", func(t *testing.T) {
+		count := func(opts ListOptions) int {
+			n := 0
+			if err := db.EachTodo(opts, func(*models.Todo) error { n++; return nil }); err != nil {
+				t.Fatalf("EachTodo: %v", err)
+			}
+			return n
+		}
+		if n := count(ListOptions{}); n != 1 {
+			t.Errorf("listed %d todos from the replica, want 1", n)
+		}
+		if n := count(ListOptions{Primary: true}); n != 3 {
+			t.Errorf("listed %d todos from the primary, want 3", n)
+		}
+
+		sample, err := db.SampleTodos(10)
+		if err != nil {
+			t.Fatalf("SampleTodos: %v", err)
+		}
+		if len(sample) != 3 {
+			t.Errorf("sampled %d todos, want all 3 from the primary", len(sample))
+		}
+
+		last, err := db.LastUpdatedAt()
+		if err != nil {
+			t.Fatalf("LastUpdatedAt: %v", err)
+		}
+		newest := get("todo-3")
+		if last == nil || !last.Equal(*newest.UpdatedAt) {
+			t.Errorf("LastUpdatedAt = %v, want the primary's %v", last, newest.UpdatedAt)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of the schema initSchema creates, stored in
+// SQLite's user_version. Bump it with every schema change, so an older
+// binary can tell it is opening a database a newer one has migrated.
+const SchemaVersion = 1
+
+// ErrSchemaTooNew is returned by New when the database was migrated by a
+// newer binary, e.g. after a rolled back deploy
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// SchemaVersion returns the schema version recorded in the database, 0 for
+// databases created before versions were recorded
+func (db *DB) SchemaVersion() (int, error) {
+	var version int
+	if err := db.conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// checkSchemaVersion rejects databases with a newer schema than this
+// binary's unless allowNewer is set
+func (db *DB) checkSchemaVersion(allowNewer bool) error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion && !allowNewer {
+		return fmt.Errorf("%w: database has version %d, this binary supports up to %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+	return nil
+}
+
+// recordSchemaVersion stores SchemaVersion after the schema was migrated.
+// A newer version is kept, so the database stays marked for older binaries.
+func (db *DB) recordSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version >= SchemaVersion {
+		return nil
+	}
+
+	// PRAGMA statements don't accept parameters
+	if _, err := db.conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// setUserVersion stores a schema version in a closed database file
+func setUserVersion(t *testing.T, path string, version int) {
+	t.Helper()
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		t.Fatalf("setting user_version: %v", err)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+
+	// A new database records the current version
+	db, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if version, err := db.SchemaVersion(); err != nil || version != SchemaVersion {
+		t.Errorf("SchemaVersion = %d (%v), want %d", version, err, SchemaVersion)
+	}
+	db.Close()
+
+	// As left behind by a newer binary before a rollback
+	setUserVersion(t, path, SchemaVersion+1)
+
+	if db, err := New(path, Options{}); !errors.Is(err, ErrSchemaTooNew) {
+		if db != nil {
+			db.Close()
+		}
+		t.Fatalf("New on a newer schema = %v, want ErrSchemaTooNew", err)
+	}
+
+	db, err = New(path, Options{AllowNewerSchema: true})
+	if err != nil {
+		t.Fatalf("New with AllowNewerSchema: %v", err)
+	}
+	// The newer version stays recorded for the next older binary
+	if version, err := db.SchemaVersion(); err != nil || version != SchemaVersion+1 {
+		t.Errorf("SchemaVersion = %d (%v), want %d", version, err, SchemaVersion+1)
+	}
+	db.Close()
+}
+
+func TestSchemaVersionRecordedForUnversionedDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todos.db")
+	db, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db.Close()
+
+	// Databases created before versions were recorded have version 0
+	setUserVersion(t, path, 0)
+
+	db, err = New(path, Options{})
+	if err != nil {
+		t.Fatalf("New on an unversioned database: %v", err)
+	}
+	defer db.Close()
+	if version, err := db.SchemaVersion(); err != nil || version != SchemaVersion {
+		t.Errorf("SchemaVersion = %d (%v), want %d", version, err, SchemaVersion)
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import "fmt"
+
+// autoVacuumIncremental is the PRAGMA auto_vacuum value of incremental mode
+const autoVacuumIncremental = 2
+
+// enableIncrementalVacuum switches the database to incremental auto_vacuum,
+// so pages freed by deletes can be returned to the OS with
+// IncrementalVacuum. The mode only takes effect when set before the first
+// table is created; existing databases are converted with a full VACUUM.
+func (db *DB) enableIncrementalVacuum() error {
+	var mode int
+	if err := db.conn.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum: %w", err)
+	}
+	if mode == autoVacuumIncremental {
+		return nil
+	}
+
+	if _, err := db.conn.Exec("PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum: %w", err)
+	}
+
+	var tables int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&tables); err != nil {
+		return fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	if tables > 0 {
+		if _, err := db.conn.Exec("VACUUM"); err != nil {
+			return fmt.Errorf("failed to convert database to incremental auto_vacuum: %w", err)
+		}
+	}
+	return nil
+}
+
+// IncrementalVacuum returns free pages to the OS and reports how many
+// bytes the database file shrank. It has no effect unless the database
+// uses incremental auto_vacuum.
+func (db *DB) IncrementalVacuum() (int64, error) {
+	before, err := db.fileSize()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := db.conn.Exec("PRAGMA incremental_vacuum"); err != nil {
+		return 0, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+
+	after, err := db.fileSize()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// fileSize returns the size of the database in bytes from its page count
+func (db *DB) fileSize() (int64, error) {
+	var pages, pageSize int64
+	if err := db.conn.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := db.conn.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to read page size: %w", err)
+	}
+	return pages * pageSize, nil
+}
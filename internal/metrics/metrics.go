@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Sync event counters, labeled by event type (created, updated, deleted)
+var (
+	SyncEventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_events_received_total",
+		Help: "Number of todo sync events received from other nodes",
+	}, []string{"type"})
+
+	SyncEventsApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_events_applied_total",
+		Help: "Number of todo sync events applied to the local database",
+	}, []string{"type"})
+
+	SyncEventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_events_failed_total",
+		Help: "Number of todo sync events that failed to decode or apply",
+	}, []string{"type"})
+)
+
+// Sync event payload bytes, labeled by event name, to quantify gossip cost
+var (
+	SyncBytesBroadcast = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_bytes_broadcast_total",
+		Help: "Bytes of sync event payloads successfully broadcast by this node",
+	}, []string{"event"})
+
+	SyncBytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_bytes_received_total",
+		Help: "Bytes of sync event payloads received, including this node's own events",
+	}, []string{"event"})
+)
+
+// MemberEvents counts cluster membership changes, labeled by type (join,
+// leave, failed, update, reap), as a signal of cluster instability
+var MemberEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "member_events_total",
+	Help: "Number of cluster membership changes observed by this node",
+}, []string{"type"})
+
+// RepairsPushed counts todos pushed to other nodes by the consistency
+// repairer, labeled by reason (missing, diverged)
+var RepairsPushed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "todo_repairs_pushed_total",
+	Help: "Number of todos pushed to nodes that were missing them or held an older copy",
+}, []string{"reason"})
+
+// FullStateQueriesRejected counts full state queries of other nodes left
+// unanswered because cluster.max_concurrent_syncs were already being served
+var FullStateQueriesRejected = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "full_state_queries_rejected_total",
+	Help: "Number of full state queries not answered because the maximum of concurrent full syncs was reached",
+})
+
+// Failed broadcasts waiting in the outbox to be retried, and those given
+// up on after cluster.broadcast_retry_max_age
+var (
+	BroadcastsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "broadcasts_queued",
+		Help: "Number of failed sync event broadcasts waiting to be retried",
+	})
+
+	BroadcastsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "broadcasts_dropped_total",
+		Help: "Number of sync event broadcasts given up on after retrying for the maximum age",
+	})
+)
+
+// Serf event processing, to spot a backlog in the serial event handler
+var (
+	EventQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "serf_event_queue_length",
+		Help: "Number of Serf events waiting in the event channel, sampled periodically",
+	})
+
+	EventProcessingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "serf_event_processing_seconds",
+		Help:    "Time spent handling a Serf event, labeled by event type or name",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8), // 0.5ms to ~8s
+	}, []string{"type"})
+)
@@ -1,31 +1,131 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultNamespace is the namespace of todos created without one
+const DefaultNamespace = "default"
+
+// Length limits of todo fields, in characters. The API enforces them via
+// the input struct tags below; cluster sync checks them explicitly.
+const (
+	MaxExternIDLength = 80
+	MaxTodoLength     = 500
+)
+
+// Limits of todo metadata
+const (
+	MaxMetadataEntries     = 16
+	MaxMetadataKeyLength   = 63
+	MaxMetadataValueLength = 256
+)
+
+// MetadataKeyPattern is the allowed format of metadata keys
+var MetadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Metadata holds arbitrary string labels of a todo, stored as a JSON object.
+// A nil Metadata is stored as NULL.
+type Metadata map[string]string
+
+// Validate checks the number of entries, the key format and the key and
+// value lengths
+func (m Metadata) Validate() error {
+	if len(m) > MaxMetadataEntries {
+		return fmt.Errorf("metadata has %d entries, at most %d are allowed", len(m), MaxMetadataEntries)
+	}
+	for key, value := range m {
+		if utf8.RuneCountInString(key) > MaxMetadataKeyLength || !MetadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata key %q: must match %s and be at most %d characters", key, MetadataKeyPattern, MaxMetadataKeyLength)
+		}
+		if utf8.RuneCountInString(value) > MaxMetadataValueLength {
+			return fmt.Errorf("metadata value of %q is longer than %d characters", key, MaxMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+// Value implements driver.Valuer
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (m *Metadata) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	}
+	return fmt.Errorf("cannot scan %T into metadata", src)
+}
 
 // Todo represents a todo item in the system
 type Todo struct {
-	ID        int       `json:"id" db:"id"`
-	ExternID  string    `json:"extern_id" db:"extern_id"`
-	Todo      string    `json:"todo" db:"todo"`
-	Completed bool      `json:"completed" db:"completed"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          int        `json:"id" db:"id"`
+	Namespace   string     `json:"namespace" db:"namespace"`
+	ExternID    string     `json:"extern_id" db:"extern_id"`
+	Todo        string     `json:"todo" db:"todo"`
+	Completed   bool       `json:"completed" db:"completed"`
+	Version     int        `json:"version" db:"version"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty" db:"updated_at"` // last local change
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	OriginNode  string     `json:"origin_node,omitempty" db:"origin_node"` // node the todo was created on, empty if unknown
+	Metadata    Metadata   `json:"metadata,omitempty" db:"metadata"`
 }
 
 // CreateTodoInput represents the input for creating a new todo
 type CreateTodoInput struct {
-	ExternID string `json:"extern_id" minLength:"1" maxLength:"80" doc:"External ID for synchronization"`
-	Todo     string `json:"todo" minLength:"1" maxLength:"500" doc:"The todo description"`
+	ExternID  string     `json:"extern_id" minLength:"1" maxLength:"80" doc:"External ID for synchronization"`
+	Todo      string     `json:"todo" minLength:"1" maxLength:"500" doc:"The todo description"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" doc:"Optional time after which the todo is deleted automatically"`
+	Metadata  Metadata   `json:"metadata,omitempty" maxProperties:"16" doc:"Optional string labels, e.g. {\"source\": \"web\"}"`
 }
 
 // UpdateTodoInput represents the input for updating a todo
 type UpdateTodoInput struct {
-	Todo      *string `json:"todo,omitempty" minLength:"1" maxLength:"500" doc:"The todo description"`
-	Completed *bool   `json:"completed,omitempty" doc:"Whether the todo is completed"`
+	Todo      *string  `json:"todo,omitempty" minLength:"1" maxLength:"500" doc:"The todo description"`
+	Completed *bool    `json:"completed,omitempty" doc:"Whether the todo is completed"`
+	Metadata  Metadata `json:"metadata,omitempty" maxProperties:"16" doc:"Replaces all labels if set; {} removes them"`
+}
+
+// PutTodoInput represents the input for creating or replacing a todo by extern_id
+type PutTodoInput struct {
+	Todo      string `json:"todo" minLength:"1" maxLength:"500" doc:"The todo description"`
+	Completed *bool  `json:"completed,omitempty" doc:"Whether the todo is completed (unchanged, or false for new todos, if omitted)"`
 }
 
 // ClusterMemberInfo represents cluster member information
 type ClusterMemberInfo struct {
-	Name   string `json:"name"`
-	Addr   string `json:"addr"`
-	Status string `json:"status"`
+	Name   string   `json:"name"`
+	Addr   string   `json:"addr"`
+	Status string   `json:"status"`
+	RTTMs  *float64 `json:"rtt_ms,omitempty"` // Estimated round-trip time to the local node (nil if unknown)
+}
+
+// ClusterEvent represents a persisted cluster membership change
+type ClusterEvent struct {
+	ID        int       `json:"id" db:"id"`
+	Node      string    `json:"node" db:"node"`
+	EventType string    `json:"event_type" db:"event_type"`
+	Addr      string    `json:"addr" db:"addr"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
@@ -0,0 +1,14 @@
+// Package version holds build information injected at link time, e.g.
+//
+//	go build -ldflags "-X github.com/c.mueller/auto-cluster-sync-demo/internal/version.Version=v1.2.0 \
+//	  -X github.com/c.mueller/auto-cluster-sync-demo/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/c.mueller/auto-cluster-sync-demo/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+//	  ./cmd/server
+package version
+
+// Build information, "dev" unless set via -ldflags
+var (
+	Version   = "dev"
+	Commit    = "dev"
+	BuildDate = "dev"
+)